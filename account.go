@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// accountRefreshInterval is how long the account information is cached before
+// it is fetched again.
+const accountRefreshInterval = time.Minute
+
+// accountInfo returns the account information, fetching it from Put.io if the
+// cached copy is older than accountRefreshInterval or a mutation has happened
+// since. On error, the last known information is returned.
+func (f *FileSystem) accountInfo(ctx context.Context) putio.AccountInfo {
+	f.accountMu.Lock()
+	defer f.accountMu.Unlock()
+
+	if !f.accountStale && time.Since(f.accountAt) < accountRefreshInterval {
+		return f.account
+	}
+
+	account, err := f.putio.Account.Info(ctx)
+	if err != nil {
+		f.logger.Printf("could not refresh account information: %v", err)
+		return f.account
+	}
+
+	f.account = account
+	f.accountAt = time.Now()
+	f.accountStale = false
+	return f.account
+}
+
+// invalidateAccount marks the cached account information as stale. It must be
+// called after every operation that changes the disk usage.
+func (f *FileSystem) invalidateAccount() {
+	f.accountMu.Lock()
+	f.accountStale = true
+	f.accountMu.Unlock()
+}
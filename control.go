@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"os"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/fuse/fuseutil"
+	"golang.org/x/net/context"
+)
+
+// controlFile is a pseudo file whose content is generated when it is opened
+// and whose writes trigger an action when the file is flushed.
+type controlFile struct {
+	fs *FileSystem
+
+	// read returns the content of the file. If it is nil, the file reads
+	// empty.
+	read func(ctx context.Context) ([]byte, error)
+
+	// write is called with everything written since the last flush. If it
+	// is nil, the file can not be written to.
+	write func(ctx context.Context, data []byte) error
+}
+
+var (
+	_ fs.Node          = (*controlFile)(nil)
+	_ fs.NodeOpener    = (*controlFile)(nil)
+	_ fs.NodeSetattrer = (*controlFile)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (c *controlFile) Attr(ctx context.Context, attr *fuse.Attr) error {
+	var mode os.FileMode
+	if c.read != nil {
+		mode |= 0400
+	}
+	if c.write != nil {
+		mode |= 0200
+	}
+	attr.Mode = mode
+	attr.Uid = c.fs.perms.uid
+	attr.Gid = c.fs.perms.gid
+	return nil
+}
+
+// Setattr implements fs.NodeSetattrer interface. Truncation, as done by
+// "echo x > file", is a no-op.
+func (c *controlFile) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	return nil
+}
+
+// Open implements fs.NodeOpener interface.
+func (c *controlFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	// bypass page cache, content changes on every open
+	resp.Flags |= fuse.OpenDirectIO
+
+	if !req.Flags.IsReadOnly() && c.write == nil {
+		return nil, fuse.EPERM
+	}
+
+	h := &controlHandle{file: c}
+	if !req.Flags.IsWriteOnly() && c.read != nil {
+		content, err := c.read(ctx)
+		if err != nil {
+			return nil, controlError(c.fs, err)
+		}
+		h.content = content
+	}
+	return h, nil
+}
+
+type controlHandle struct {
+	file *controlFile
+
+	content []byte
+	buf     bytes.Buffer
+}
+
+var (
+	_ fs.HandleReader  = (*controlHandle)(nil)
+	_ fs.HandleWriter  = (*controlHandle)(nil)
+	_ fs.HandleFlusher = (*controlHandle)(nil)
+)
+
+// Read implements fs.HandleReader interface.
+func (h *controlHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	fuseutil.HandleRead(req, resp, h.content)
+	return nil
+}
+
+// Write implements fs.HandleWriter interface. Offsets are ignored, every
+// write is appended.
+func (h *controlHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	h.buf.Write(req.Data)
+	resp.Size = len(req.Data)
+	return nil
+}
+
+// Flush implements fs.HandleFlusher interface.
+func (h *controlHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	if h.buf.Len() == 0 {
+		return nil
+	}
+
+	data := append([]byte(nil), h.buf.Bytes()...)
+	h.buf.Reset()
+
+	if err := h.file.write(ctx, data); err != nil {
+		return controlError(h.file.fs, err)
+	}
+	return nil
+}
+
+// controlError converts an error of a control file action to a FUSE error.
+// Errors that are not FUSE errors are logged and reported as EIO.
+func controlError(f *FileSystem, err error) error {
+	if _, ok := err.(fuse.ErrorNumber); ok {
+		return err
+	}
+	f.logger.Printf("control file: %v", err)
+	return fuse.EIO
+}
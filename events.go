@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+)
+
+const (
+	// eventsPollInterval is how often the events are fetched while .events
+	// is being watched.
+	eventsPollInterval = 10 * time.Second
+
+	// maxEventLogSize is the number of bytes of events kept in .events. The
+	// older events read as empty lines.
+	maxEventLogSize = 256 << 10
+)
+
+// eventLog is the content of the .events file: the events that arrived since
+// the mount, oldest first, one JSON object per line. Events that were already
+// on Put.io at the mount are left out. It only grows, so that it can be
+// followed with "tail -f". Events are fetched when the file is opened or
+// stat'ed, at most once per eventsPollInterval.
+type eventLog struct {
+	fs *FileSystem
+
+	// content is the kept tail of the log, base is the offset of its first
+	// byte in the whole log.
+	mu       sync.Mutex
+	content  []byte
+	base     int64
+	lastID   int64
+	started  bool
+	polledAt time.Time
+	mtime    time.Time
+}
+
+// refresh appends the events that are not seen yet.
+func (l *eventLog) refresh(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if time.Since(l.polledAt) < eventsPollInterval {
+		return
+	}
+	l.polledAt = time.Now()
+
+	events, err := l.fs.putio.Events.List(ctx)
+	if err != nil {
+		l.fs.logger.Printf("could not list events: %v", err)
+		return
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	for _, event := range events {
+		if event.ID <= l.lastID {
+			continue
+		}
+		l.lastID = event.ID
+
+		// the events before the first fetch are history.
+		if !l.started {
+			continue
+		}
+
+		b, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		l.content = append(l.content, b...)
+		l.content = append(l.content, '\n')
+		l.mtime = time.Now()
+	}
+	l.started = true
+
+	if len(l.content) > maxEventLogSize {
+		// drop whole lines only.
+		cut := len(l.content) - maxEventLogSize
+		if i := bytes.IndexByte(l.content[cut:], '\n'); i >= 0 {
+			cut += i + 1
+		} else {
+			cut = len(l.content)
+		}
+		l.content = append([]byte(nil), l.content[cut:]...)
+		l.base += int64(cut)
+	}
+}
+
+// size returns the size of the whole log and when it last changed.
+func (l *eventLog) size() (int64, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.base + int64(len(l.content)), l.mtime
+}
+
+// readAt returns up to size bytes of the whole log from offset. The dropped
+// part of the log reads as empty lines, so that the offsets of the kept
+// events do not change.
+func (l *eventLog) readAt(offset int64, size int) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	end := minInt64(offset+int64(size), l.base+int64(len(l.content)))
+	if offset >= end {
+		return nil
+	}
+
+	buf := make([]byte, 0, end-offset)
+	for ; offset < l.base && offset < end; offset++ {
+		buf = append(buf, '\n')
+	}
+	if offset < end {
+		buf = append(buf, l.content[offset-l.base:end-l.base]...)
+	}
+	return buf
+}
+
+// eventsFile is the .events file. Unlike the other pseudo files it goes
+// through the page cache: its size grows as new events arrive, which is what
+// "tail -f" polls for.
+type eventsFile struct {
+	fs *FileSystem
+}
+
+var (
+	_ fs.Node         = (*eventsFile)(nil)
+	_ fs.NodeOpener   = (*eventsFile)(nil)
+	_ fs.HandleReader = (*eventsFile)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (e *eventsFile) Attr(ctx context.Context, attr *fuse.Attr) error {
+	e.fs.events.refresh(ctx)
+	size, mtime := e.fs.events.size()
+
+	// let the kernel ask for the size every time.
+	attr.Valid = 0
+	attr.Mode = 0444
+	attr.Uid = e.fs.perms.uid
+	attr.Gid = e.fs.perms.gid
+	attr.Size = uint64(size)
+	attr.Mtime = mtime
+	attr.Ctime = mtime
+	return nil
+}
+
+// Open implements fs.NodeOpener interface.
+func (e *eventsFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.EPERM
+	}
+	e.fs.events.refresh(ctx)
+	return e, nil
+}
+
+// Read implements fs.HandleReader interface.
+func (e *eventsFile) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	resp.Data = e.fs.events.readAt(req.Offset, req.Size)
+	return nil
+}
+
+// eventsClearFile is the .events.clear control file. Writing into it clears
+// the events on Put.io. The events already in .events stay there.
+func (f *FileSystem) eventsClearFile() *controlFile {
+	return &controlFile{
+		fs: f,
+		write: func(ctx context.Context, data []byte) error {
+			return f.putio.Events.Delete(ctx)
+		},
+	}
+}
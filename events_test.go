@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestEventLogReadAt(t *testing.T) {
+	// the first 4 bytes of the log are dropped.
+	l := &eventLog{content: []byte("{}\n{}\n"), base: 4}
+
+	tests := []struct {
+		offset int64
+		size   int
+		want   string
+	}{
+		{0, 100, "\n\n\n\n{}\n{}\n"},
+		{0, 2, "\n\n"},
+		{2, 4, "\n\n{}"},
+		{4, 3, "{}\n"},
+		{7, 100, "{}\n"},
+		{8, 100, "}\n"},
+		{10, 100, ""},
+		{20, 100, ""},
+	}
+
+	for _, tt := range tests {
+		if got := string(l.readAt(tt.offset, tt.size)); got != tt.want {
+			t.Errorf("readAt(%v, %v) = %q, want %q", tt.offset, tt.size, got, tt.want)
+		}
+	}
+}
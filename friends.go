@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// friendFile returns the file of a friend or a friend request. It reads as
+// its JSON state.
+func (f *FileSystem) friendFile(friend putio.Friend, write func(ctx context.Context, data []byte) error) *controlFile {
+	return &controlFile{
+		fs: f,
+		read: func(ctx context.Context) ([]byte, error) {
+			b, err := json.MarshalIndent(friend, "", "  ")
+			return append(b, '\n'), err
+		},
+		write: write,
+	}
+}
+
+// findFriend returns the friend with the given name in friends.
+func findFriend(friends []putio.Friend, name string) (putio.Friend, bool) {
+	for _, friend := range friends {
+		if friend.Name == name {
+			return friend, true
+		}
+	}
+	return putio.Friend{}, false
+}
+
+// friendDirents returns the directory entries of friends, after the given
+// control entries.
+func friendDirents(friends []putio.Friend, controls ...string) []fuse.Dirent {
+	var entries []fuse.Dirent
+	for _, name := range controls {
+		entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_File})
+	}
+	for _, friend := range friends {
+		entries = append(entries, fuse.Dirent{Name: friend.Name, Type: fuse.DT_File})
+	}
+	return entries
+}
+
+// friendsDir is the .friends directory. Each friend is a file, removing it
+// unfriends them. Writing user names into .request sends them friend
+// requests, the requests waiting for an answer are in .pending.
+type friendsDir struct {
+	fs *FileSystem
+}
+
+var (
+	_ fs.Node                = (*friendsDir)(nil)
+	_ fs.NodeRequestLookuper = (*friendsDir)(nil)
+	_ fs.HandleReadDirAller  = (*friendsDir)(nil)
+	_ fs.NodeRemover         = (*friendsDir)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (d *friendsDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	d.fs.perms.dir(attr, false)
+	return nil
+}
+
+// Lookup implements fs.NodeRequestLookuper interface.
+func (d *friendsDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	d.fs.logger.Debugf("friendsDir.Lookup(%q)", req.Name)
+
+	switch req.Name {
+	case ".pending":
+		return &pendingFriendsDir{fs: d.fs}, nil
+	case ".request":
+		return &controlFile{
+			fs: d.fs,
+			write: func(ctx context.Context, data []byte) error {
+				names, err := parseNames(data)
+				if err != nil {
+					d.fs.logger.Printf("could not read the friend requests: %v", err)
+					return fuse.Errno(syscall.EINVAL)
+				}
+				for _, name := range names {
+					if err := d.fs.putio.Friends.Request(ctx, name); err != nil {
+						d.fs.logger.Printf("could not send friend request to %q: %v", name, err)
+						return fuse.EIO
+					}
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	friends, err := d.fs.putio.Friends.List(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not list friends: %v", err)
+		return nil, fuse.EIO
+	}
+
+	friend, ok := findFriend(friends, req.Name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return d.fs.friendFile(friend, nil), nil
+}
+
+// ReadDirAll implements fs.HandleReadDirAller interface.
+func (d *friendsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.logger.Debugf("friendsDir.ReadDirAll()")
+
+	friends, err := d.fs.putio.Friends.List(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not list friends: %v", err)
+		return nil, fuse.EIO
+	}
+
+	entries := friendDirents(friends, ".request")
+	return append(entries, fuse.Dirent{Name: ".pending", Type: fuse.DT_Dir}), nil
+}
+
+// Remove implements fs.NodeRemover interface. Removing a friend unfriends
+// them.
+func (d *friendsDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.logger.Debugf("friendsDir.Remove(%q)", req.Name)
+
+	if strings.HasPrefix(req.Name, ".") {
+		return fuse.EPERM
+	}
+	if err := d.fs.putio.Friends.Unfriend(ctx, req.Name); err != nil {
+		d.fs.logger.Printf("could not unfriend %q: %v", req.Name, err)
+		return fuse.EIO
+	}
+	return nil
+}
+
+// pendingFriendsDir is the .friends/.pending directory. Each friend request is
+// a file. Writing "approve" or "deny" into it answers the request, removing
+// it denies the request.
+type pendingFriendsDir struct {
+	fs *FileSystem
+}
+
+var (
+	_ fs.Node                = (*pendingFriendsDir)(nil)
+	_ fs.NodeRequestLookuper = (*pendingFriendsDir)(nil)
+	_ fs.HandleReadDirAller  = (*pendingFriendsDir)(nil)
+	_ fs.NodeRemover         = (*pendingFriendsDir)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (d *pendingFriendsDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	d.fs.perms.dir(attr, false)
+	return nil
+}
+
+// Lookup implements fs.NodeRequestLookuper interface.
+func (d *pendingFriendsDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	d.fs.logger.Debugf("pendingFriendsDir.Lookup(%q)", req.Name)
+
+	friends, err := d.fs.putio.Friends.WaitingRequests(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not list friend requests: %v", err)
+		return nil, fuse.EIO
+	}
+
+	friend, ok := findFriend(friends, req.Name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return d.fs.friendFile(friend, func(ctx context.Context, data []byte) error {
+		switch cmd := strings.TrimSpace(string(data)); cmd {
+		case "approve":
+			return d.fs.putio.Friends.Approve(ctx, friend.Name)
+		case "deny":
+			return d.fs.putio.Friends.Deny(ctx, friend.Name)
+		default:
+			d.fs.logger.Printf("unknown friend request command %q", cmd)
+			return fuse.Errno(syscall.EINVAL)
+		}
+	}), nil
+}
+
+// ReadDirAll implements fs.HandleReadDirAller interface.
+func (d *pendingFriendsDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.logger.Debugf("pendingFriendsDir.ReadDirAll()")
+
+	friends, err := d.fs.putio.Friends.WaitingRequests(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not list friend requests: %v", err)
+		return nil, fuse.EIO
+	}
+	return friendDirents(friends), nil
+}
+
+// Remove implements fs.NodeRemover interface. Removing a friend request
+// denies it.
+func (d *pendingFriendsDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.logger.Debugf("pendingFriendsDir.Remove(%q)", req.Name)
+
+	if err := d.fs.putio.Friends.Deny(ctx, req.Name); err != nil {
+		d.fs.logger.Printf("could not deny friend request of %q: %v", req.Name, err)
+		return fuse.EIO
+	}
+	return nil
+}
+
+// parseNames returns the user names in data, separated by commas or new
+// lines.
+func parseNames(data []byte) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		for _, name := range strings.Split(scanner.Text(), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, scanner.Err()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNames(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{"", nil},
+		{"\n", nil},
+		{" , ,\n", nil},
+		{"alice\n", []string{"alice"}},
+		{"alice,bob", []string{"alice", "bob"}},
+		{" alice , bob \ncarol\n\n", []string{"alice", "bob", "carol"}},
+		{"everyone\n", []string{"everyone"}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseNames([]byte(tt.data))
+		if err != nil {
+			t.Errorf("parseNames(%q) failed: %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNames(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseNamesLongLine(t *testing.T) {
+	data := "alice\n" + strings.Repeat("x", 128<<10)
+	if _, err := parseNames([]byte(data)); err == nil {
+		t.Errorf("parseNames of a line longer than the scanner buffer did not fail")
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -26,12 +28,120 @@ import (
 
 const defaultUserAgent = "putiofs - FUSE bridge to Put.io"
 
+// Options configures the behaviour of a FileSystem.
+type Options struct {
+	// Debug enables debug logging.
+	Debug bool
+
+	// Trash makes deletions move files into a hidden trash folder instead
+	// of deleting them permanently.
+	Trash bool
+
+	// TrashRetention is how long trashed files are kept before they are
+	// purged. Zero keeps them forever.
+	TrashRetention time.Duration
+
+	// StagingDir is the directory written files are staged in before they
+	// are uploaded. The system temp dir is used if it is empty.
+	StagingDir string
+
+	// StagingLimit is the maximum total size of the staged files. Zero is
+	// unlimited.
+	StagingLimit int64
+
+	// StagingBlock makes writes wait for staged files to be released when
+	// the staging limit is reached, instead of failing with ENOSPC.
+	StagingBlock bool
+
+	// Uid and Gid own the files in the mount. A negative value means the
+	// user running putiofs.
+	Uid int
+	Gid int
+
+	// DirMode and FileMode are the permission bits of directories and
+	// files. Zero means 0755 and 0644 respectively.
+	DirMode  os.FileMode
+	FileMode os.FileMode
+
+	// Umask is cleared from the permission bits of every file.
+	Umask os.FileMode
+
+	// MetadataFile is where mode, owner and mtime changes are stored. Put.io
+	// can not store them; if it is empty, they are ignored.
+	MetadataFile string
+
+	// LockFiles makes files opened for writing locked with lock files on
+	// Put.io, so that other instances sharing the account do not edit them
+	// at the same time.
+	LockFiles bool
+
+	// Versions is the number of previous versions kept when a file is
+	// overwritten. Zero disables versioning.
+	Versions int
+
+	// KeepTorrents keeps the .torrent files written into the mount on
+	// Put.io. They only start transfers otherwise.
+	KeepTorrents bool
+
+	// Subtitles are the formats of the subtitle files presented next to
+	// videos, "srt" or "vtt". Empty disables them.
+	Subtitles []string
+}
+
 // FileSystem is the main object that represents a Put.io filesystem.
 type FileSystem struct {
-	logger  *Logger
-	putio   *putio.Client
-	hc      *http.Client
-	account putio.AccountInfo
+	logger *Logger
+	putio  *putio.Client
+	hc     *http.Client
+	perms  perms
+
+	// accountMu guards the cached account information.
+	accountMu    sync.Mutex
+	account      putio.AccountInfo
+	accountAt    time.Time
+	accountStale bool
+
+	stagingDir   string
+	stagingLimit int64
+	stagingBlock bool
+
+	// stagingMu guards staging, the open file handles with staged content,
+	// and stagingFreed, which is closed when staging space is released.
+	stagingMu    sync.Mutex
+	staging      map[*fileHandle]struct{}
+	stagingFreed chan struct{}
+
+	// trash is nil if trash mode is disabled.
+	trash *trash
+
+	// versions is nil if versioning is disabled.
+	versions *versions
+
+	// meta is nil if the metadata overlay is disabled.
+	meta *metaStore
+
+	// instance identifies this putiofs instance in lock files. locks are
+	// the lock files held by this instance, lockMus serialize the lock file
+	// round-trips per file. locksMu guards both maps.
+	lockFiles bool
+	instance  string
+	locksMu   sync.Mutex
+	locks     map[string]*remoteLock
+	lockMus   map[string]*keyMutex
+
+	// dirStatsMu guards dirStats, the attributes of the directories seen so
+	// far by ID.
+	dirStatsMu sync.Mutex
+	dirStats   map[int64]dirStat
+
+	keepTorrents    bool
+	transferResults transferResults
+
+	subtitleFormats []string
+	subtitleCache   subtitleCache
+
+	events *eventLog
+	zips   zipBuilds
 }
 
 var (
@@ -40,7 +150,7 @@ var (
 )
 
 // NewFileSystem returns a new Put.io FUSE filesystem.
-func NewFileSystem(token string, debug bool) *FileSystem {
+func NewFileSystem(token string, opts Options) (*FileSystem, error) {
 	oauthClient := oauth2.NewClient(
 		context.Background(),
 		oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}),
@@ -48,11 +158,56 @@ func NewFileSystem(token string, debug bool) *FileSystem {
 	client := putio.NewClient(oauthClient)
 	client.UserAgent = defaultUserAgent
 
-	return &FileSystem{
+	fsys := &FileSystem{
 		putio:  client,
 		hc:     &http.Client{Timeout: time.Hour},
-		logger: NewLogger("putiofs: ", debug),
+		logger: NewLogger("putiofs: ", opts.Debug),
+		perms:  newPerms(opts),
+
+		stagingDir:   opts.StagingDir,
+		stagingLimit: opts.StagingLimit,
+		stagingBlock: opts.StagingBlock,
+		stagingFreed: make(chan struct{}),
+
+		lockFiles: opts.LockFiles,
+		instance:  newInstanceID(),
+		locks:     make(map[string]*remoteLock),
+		lockMus:   make(map[string]*keyMutex),
+
+		keepTorrents: opts.KeepTorrents,
+	}
+	for _, format := range opts.Subtitles {
+		if !isSubtitleFormat(format) {
+			return nil, fmt.Errorf("unknown subtitle format %q", format)
+		}
+		fsys.subtitleFormats = append(fsys.subtitleFormats, format)
+	}
+	fsys.events = &eventLog{fs: fsys}
+	if opts.Trash {
+		fsys.trash = newTrash(fsys, opts.TrashRetention)
+	}
+	if opts.Versions > 0 {
+		fsys.versions = newVersions(fsys, opts.Versions)
 	}
+	if opts.MetadataFile != "" {
+		meta, err := openMetaStore(opts.MetadataFile, fsys.logger)
+		if err != nil {
+			return nil, fmt.Errorf("could not open metadata file: %v", err)
+		}
+		fsys.meta = meta
+	}
+	return fsys, nil
+}
+
+// Close saves the state that is kept locally. It is called when the file
+// system is unmounted.
+func (f *FileSystem) Close() error {
+	if f.meta != nil {
+		if err := f.meta.sync(); err != nil {
+			return fmt.Errorf("could not save metadata: %v", err)
+		}
+	}
+	return nil
 }
 
 func (f *FileSystem) list(ctx context.Context, id int64) ([]putio.File, error) {
@@ -64,8 +219,102 @@ func (f *FileSystem) get(ctx context.Context, id int64) (putio.File, error) {
 	return f.putio.Files.Get(ctx, id)
 }
 
-func (f *FileSystem) remove(ctx context.Context, id int64) error {
-	return f.putio.Files.Delete(ctx, id)
+// remove deletes the given file. If trash mode is enabled, the file is moved
+// into the trash instead.
+func (f *FileSystem) remove(ctx context.Context, file putio.File) error {
+	if f.trash != nil {
+		return f.trash.put(ctx, file)
+	}
+	return f.delete(ctx, file.ID)
+}
+
+// delete deletes the given files permanently.
+func (f *FileSystem) delete(ctx context.Context, ids ...int64) error {
+	defer f.invalidateAccount()
+	if err := f.putio.Files.Delete(ctx, ids...); err != nil {
+		return err
+	}
+
+	if f.meta != nil {
+		f.meta.forget(ids...)
+	}
+	return nil
+}
+
+// upload uploads the content of r as a new file under parent.
+func (f *FileSystem) upload(ctx context.Context, r io.Reader, filename string, parent int64) (putio.Upload, error) {
+	defer f.invalidateAccount()
+	return f.putio.Files.Upload(ctx, r, filename, parent)
+}
+
+// supersede gets rid of a file that is about to be overwritten. size is the
+// size of the file on Put.io, file.Size may already be the size of the new
+// content. If versioning is enabled, the file is kept as a previous version,
+// otherwise it is removed.
+func (f *FileSystem) supersede(ctx context.Context, file *putio.File, size int64) error {
+	// empty files are the placeholders created by Dir.Create. they are not
+	// worth keeping, neither as versions nor in the trash.
+	if size == 0 {
+		return f.delete(ctx, file.ID)
+	}
+	if f.versions != nil {
+		return f.versions.put(ctx, file)
+	}
+	return f.remove(ctx, *file)
+}
+
+// setattr stores the mode, owner and mtime changes of req in the metadata
+// overlay. They are ignored if the overlay is disabled.
+func (f *FileSystem) setattr(id int64, req *fuse.SetattrRequest) error {
+	if f.meta == nil {
+		return nil
+	}
+	if !req.Valid.Mode() && !req.Valid.Uid() && !req.Valid.Gid() && !req.Valid.Mtime() && !req.Valid.MtimeNow() {
+		return nil
+	}
+
+	// files kept locally have no ID to keep their metadata under. The
+	// changes are ignored so that copying them with "cp -p" still works.
+	if id < 0 {
+		return nil
+	}
+
+	f.meta.update(id, func(m *fileMeta) {
+		m.setattr(req)
+	})
+	return nil
+}
+
+// parentNames returns the names of the folders from the root down to the
+// folder with the given ID.
+func (f *FileSystem) parentNames(ctx context.Context, id int64) ([]string, error) {
+	var names []string
+	for id != 0 {
+		folder, err := f.get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		names = append([]string{folder.Name}, names...)
+		id = folder.ParentID
+	}
+	return names, nil
+}
+
+// findOrCreateFolder returns the folder with the given name under parent,
+// creating it if it does not exist.
+func (f *FileSystem) findOrCreateFolder(ctx context.Context, name string, parent int64) (putio.File, error) {
+	files, err := f.list(ctx, parent)
+	if err != nil {
+		return putio.File{}, err
+	}
+
+	for _, file := range files {
+		if file.Name == name && file.IsDir() {
+			return file, nil
+		}
+	}
+
+	return f.putio.Files.CreateFolder(ctx, name, parent)
 }
 
 func (f *FileSystem) download(ctx context.Context, id int64, offset int64, size int) (io.ReadCloser, error) {
@@ -74,7 +323,11 @@ func (f *FileSystem) download(ctx context.Context, id int64, offset int64, size
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch file URL: %v", err)
 	}
+	return f.downloadURL(ctx, u, offset, size)
+}
 
+// downloadURL downloads size bytes at offset of the file at the given URL.
+func (f *FileSystem) downloadURL(ctx context.Context, u string, offset int64, size int) (io.ReadCloser, error) {
 	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create a new request: %v", err)
@@ -86,7 +339,7 @@ func (f *FileSystem) download(ctx context.Context, id int64, offset int64, size
 
 	{
 		b, _ := httputil.DumpRequest(req, false)
-		f.logger.Debugf("download request dump of %v [offset: %v]:\n%v", id, offset, string(b))
+		f.logger.Debugf("download request dump of %v [offset: %v]:\n%v", u, offset, string(b))
 	}
 
 	resp, err := f.hc.Do(req)
@@ -96,7 +349,7 @@ func (f *FileSystem) download(ctx context.Context, id int64, offset int64, size
 
 	{
 		b, _ := httputil.DumpResponse(resp, false)
-		f.logger.Debugf("download response dump of %v [offset: %v]:\n%v", id, offset, string(b))
+		f.logger.Debugf("download response dump of %v [offset: %v]:\n%v", u, offset, string(b))
 
 	}
 	return resp.Body, nil
@@ -127,6 +380,11 @@ func (f *FileSystem) Root() (fs.Node, error) {
 		return nil, fuse.EIO
 	}
 	f.account = account
+	f.accountAt = time.Now()
+
+	if f.trash != nil {
+		go f.trash.run()
+	}
 
 	return &Dir{
 		fs:   f,
@@ -139,10 +397,17 @@ func (f *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *
 	// each block size is 4096 bytes by default.
 	const unit = uint64(4096)
 
+	account := f.accountInfo(ctx)
+
 	resp.Bsize = uint32(unit)
-	resp.Blocks = uint64(f.account.Disk.Size) / unit
-	resp.Bavail = uint64(f.account.Disk.Avail) / unit
-	resp.Bfree = uint64(f.account.Disk.Avail) / unit
+	resp.Frsize = uint32(unit)
+	resp.Blocks = uint64(account.Disk.Size) / unit
+	resp.Bavail = uint64(account.Disk.Avail) / unit
+	resp.Bfree = uint64(account.Disk.Avail) / unit
+	resp.Namelen = 255
+
+	// Put.io does not report file counts. leave them zero, which df shows
+	// as unknown.
 
 	return nil
 }
@@ -153,6 +418,42 @@ type Dir struct {
 
 	// metadata
 	*putio.File
+
+	// trashed reports whether the directory is the trash folder or lives
+	// under it. Removals in a trashed directory are permanent.
+	trashed bool
+}
+
+// dirStat holds the attributes of a directory that Put.io does not track.
+// They are derived from the children seen in the last listing and the
+// changes made through the mount. The nodes of a directory come and go with
+// the lookups, so they are kept on the filesystem.
+type dirStat struct {
+	// listed reports whether subdirs is known.
+	listed  bool
+	subdirs uint32
+	mtime   time.Time
+}
+
+// dirStat returns the attributes of the directory with the given ID.
+func (f *FileSystem) dirStat(id int64) dirStat {
+	f.dirStatsMu.Lock()
+	defer f.dirStatsMu.Unlock()
+	return f.dirStats[id]
+}
+
+// updateDirStat changes the attributes of the directory with the given ID
+// with fn.
+func (f *FileSystem) updateDirStat(id int64, fn func(*dirStat)) {
+	f.dirStatsMu.Lock()
+	defer f.dirStatsMu.Unlock()
+
+	if f.dirStats == nil {
+		f.dirStats = make(map[int64]dirStat)
+	}
+	st := f.dirStats[id]
+	fn(&st)
+	f.dirStats[id] = st
 }
 
 var (
@@ -164,6 +465,11 @@ var (
 	_ fs.HandleReadDirAller  = (*Dir)(nil)
 	_ fs.NodeSymlinker       = (*Dir)(nil)
 	_ fs.NodeRenamer         = (*Dir)(nil)
+	_ fs.NodeSetattrer       = (*Dir)(nil)
+	_ fs.NodeGetxattrer      = (*Dir)(nil)
+	_ fs.NodeListxattrer     = (*Dir)(nil)
+	_ fs.NodeSetxattrer      = (*Dir)(nil)
+	_ fs.NodeRemovexattrer   = (*Dir)(nil)
 )
 
 func (d *Dir) String() string {
@@ -175,27 +481,165 @@ func (d *Dir) String() string {
 func (d *Dir) Attr(ctx context.Context, attr *fuse.Attr) error {
 	d.fs.logger.Debugf("dir.Attr(%q)", d.Name)
 
-	attr.Mode = os.ModeDir | 0755
-	attr.Uid = uint32(os.Getuid())
-	attr.Gid = uint32(os.Getgid())
+	d.fs.perms.dir(attr, false)
 	attr.Size = uint64(d.Size)
+	if d.CreatedAt != nil {
+		attr.Ctime = d.CreatedAt.Time
+		attr.Crtime = d.CreatedAt.Time
+		attr.Mtime = d.CreatedAt.Time
+	}
+
+	st := d.fs.dirStat(d.ID)
+	if st.mtime.After(attr.Mtime) {
+		attr.Mtime = st.mtime
+		attr.Ctime = st.mtime
+	}
+	// a directory is linked from its parent, from its own "." and from
+	// the ".." of each subdirectory. Until it is listed, the count is
+	// unknown, which is 1 for tools like find.
+	attr.Nlink = 1
+	if st.listed {
+		attr.Nlink = 2 + st.subdirs
+	}
+
+	if d.fs.meta != nil {
+		if m, ok := d.fs.meta.get(d.ID); ok {
+			m.apply(attr)
+		}
+	}
 	return nil
 }
 
+// Setattr implements fs.NodeSetattrer interface. Mode, owner and mtime
+// changes are kept in the metadata overlay.
+func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	d.fs.logger.Debugf("dir.Setattr(%q)", d.Name)
+
+	return d.fs.setattr(d.ID, req)
+}
+
+// list lists the children of the directory and updates the directory
+// attributes derived from them.
+func (d *Dir) list(ctx context.Context) ([]putio.File, error) {
+	files, err := d.fs.list(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var subdirs uint32
+	var newest time.Time
+	for _, file := range files {
+		if file.IsDir() && !d.isHidden(file) {
+			subdirs++
+		}
+		if file.CreatedAt != nil && file.CreatedAt.After(newest) {
+			newest = file.CreatedAt.Time
+		}
+	}
+
+	d.fs.updateDirStat(d.ID, func(st *dirStat) {
+		st.listed = true
+		st.subdirs = subdirs
+		if newest.After(st.mtime) {
+			st.mtime = newest
+		}
+	})
+
+	return files, nil
+}
+
+// touch records a change made to the directory through the mount.
+func (d *Dir) touch() {
+	d.fs.updateDirStat(d.ID, func(st *dirStat) {
+		st.mtime = time.Now()
+	})
+}
+
+// Getxattr implements the fs.NodeGetxattrer interface. Put.io metadata is
+// exposed as user.putio.* attributes.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	d.fs.logger.Debugf("dir.Getxattr(%q, %q)", d.Name, req.Name)
+
+	return d.fs.getxattr(ctx, d.File, req, resp)
+}
+
+// Listxattr implements the fs.NodeListxattrer interface.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	d.fs.logger.Debugf("dir.Listxattr(%q)", d.Name)
+
+	d.fs.listxattr(d.File, resp)
+	return nil
+}
+
+// Setxattr implements the fs.NodeSetxattrer interface. User defined
+// attributes are kept in the metadata overlay.
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	d.fs.logger.Debugf("dir.Setxattr(%q, %q)", d.Name, req.Name)
+
+	return d.fs.setxattr(ctx, d.ID, req)
+}
+
+// Removexattr implements the fs.NodeRemovexattrer interface.
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	d.fs.logger.Debugf("dir.Removexattr(%q, %q)", d.Name, req.Name)
+
+	return d.fs.removexattr(ctx, d.ID, req)
+}
+
 // Create implements fs.NodeCreater interface. It is called to create and open
 // a new file.
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
 	d.fs.logger.Debugf("dir.Create(%q)", d.Name)
 
-	u, err := d.fs.putio.Files.Upload(ctx, strings.NewReader(""), req.Name, d.ID)
-	if err != nil {
-		d.fs.logger.Printf("could not create file on remote: %v", err)
-		return nil, nil, fuse.EIO
+	if err := d.fs.hasRoom(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var lock *remoteLock
+	if d.fs.lockFiles {
+		l, err := d.fs.lock(ctx, d.ID, req.Name)
+		if err == errLocked {
+			return nil, nil, err
+		}
+		if err != nil {
+			d.fs.logger.Printf("could not lock %q: %v", req.Name, err)
+			return nil, nil, fuse.EIO
+		}
+		lock = l
 	}
 
-	f := &File{fs: d.fs, File: u.File}
-	h, err := f.newHandle()
-	return f, h, err
+	// torrent and link files are not stored on Put.io, they are kept
+	// locally until they are written.
+	file := &putio.File{
+		ID:        -1,
+		Name:      req.Name,
+		ParentID:  d.ID,
+		CreatedAt: &putio.Time{Time: time.Now()},
+	}
+	if !isLocalName(req.Name) {
+		u, err := d.fs.upload(ctx, strings.NewReader(""), req.Name, d.ID)
+		if err != nil {
+			d.fs.logger.Printf("could not create file on remote: %v", err)
+			if lock != nil {
+				d.fs.unlock(ctx, lock)
+			}
+			return nil, nil, fuse.EIO
+		}
+		file = u.File
+	}
+
+	d.touch()
+
+	f := &File{fs: d.fs, File: file}
+	h, err := f.newHandle(true)
+	if err != nil {
+		if lock != nil {
+			d.fs.unlock(ctx, lock)
+		}
+		return nil, nil, err
+	}
+	h.lock = lock
+	return f, h, nil
 }
 
 // Mkdir implements fs.NodeMkdirer interface. It is called to create a new
@@ -203,14 +647,14 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
 	d.fs.logger.Debugf("dir.Mkdir(%q)", d.Name)
 
-	files, err := d.fs.list(ctx, d.ID)
+	files, err := d.list(ctx)
 	if err != nil {
 		d.fs.logger.Printf("could not list directory %q: %v", d, err)
 		return nil, fuse.EIO
 	}
 
 	for _, file := range files {
-		if file.Name == req.Name {
+		if entryName(&file) == req.Name {
 			return nil, fuse.EEXIST
 		}
 	}
@@ -220,6 +664,7 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
 		d.fs.logger.Printf("could not create folder: %v", err)
 		return nil, fuse.EIO
 	}
+	d.touch()
 
 	return &Dir{
 		fs:   d.fs,
@@ -240,46 +685,137 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 	// reserved filename lookups
 	switch filename {
 	case ".quit":
+		if err := d.fs.Close(); err != nil {
+			d.fs.logger.Printf("%v", err)
+		}
 		d.fs.logger.Fatalf("Shutting down due to request .quit lookup\n")
 	case ".stat":
 		f, _ := d.fs.get(ctx, d.ID)
 		stat, _ := json.MarshalIndent(f, "", "  ")
-		return staticFileNode(stat), nil
+		return d.fs.staticFile(string(stat)), nil
 	case ".account":
-		acc, _ := json.MarshalIndent(d.fs.account, "", "  ")
-		return staticFileNode(acc), nil
+		acc, _ := json.MarshalIndent(d.fs.accountInfo(ctx), "", "  ")
+		return d.fs.staticFile(string(acc)), nil
 	case ".transfers":
-		ts, err := d.fs.putio.Transfers.List(ctx)
-		if err != nil {
-			d.fs.logger.Printf("could not list transfers: %v", err)
-			return nil, fuse.EIO
+		return d.fs.transfersFile(d.ID), nil
+	case ".transfers.d":
+		return &transfersDir{fs: d.fs}, nil
+	case ".uploads":
+		return d.fs.staticFile(d.fs.printUploadsChart()), nil
+	case ".settings":
+		return d.fs.settingsFile(), nil
+	case ".search":
+		if d.isRoot() {
+			return &searchDir{fs: d.fs}, nil
+		}
+	case ".friends":
+		if d.isRoot() {
+			return &friendsDir{fs: d.fs}, nil
+		}
+	case ".shared-with-me":
+		if d.isRoot() {
+			folder, ok, err := d.fs.sharedRoot(ctx)
+			if err != nil {
+				d.fs.logger.Printf("could not find the shared folder: %v", err)
+				return nil, fuse.EIO
+			}
+			if !ok {
+				return nil, fuse.ENOENT
+			}
+			return &readonlyDir{
+				fs:   d.fs,
+				File: &folder,
+			}, nil
+		}
+	case ".events":
+		return &eventsFile{fs: d.fs}, nil
+	case ".events.clear":
+		return d.fs.eventsClearFile(), nil
+	case ".trash":
+		if d.isRoot() && d.fs.trash != nil {
+			folder, err := d.fs.trash.root(ctx)
+			if err != nil {
+				d.fs.logger.Printf("could not get trash folder: %v", err)
+				return nil, fuse.EIO
+			}
+			return &Dir{
+				fs:      d.fs,
+				File:    folder,
+				trashed: true,
+			}, nil
+		}
+	case ".versions":
+		if d.fs.versions != nil && !d.trashed {
+			dir, ok, err := d.fs.versions.dir(ctx, d.ID)
+			if err != nil {
+				d.fs.logger.Printf("could not get versions folder: %v", err)
+				return nil, fuse.EIO
+			}
+			if !ok {
+				return nil, fuse.ENOENT
+			}
+			return &readonlyDir{
+				fs:   d.fs,
+				File: &dir,
+			}, nil
 		}
-		return staticFileNode(printTransfersChart(ts)), nil
 	}
 
-	files, err := d.fs.list(ctx, d.ID)
+	files, err := d.list(ctx)
 	if err != nil {
 		d.fs.logger.Printf("could not lookup file %q: %v", d, err)
 		return nil, fuse.EIO
 	}
 
 	for _, file := range files {
-		if file.Name != filename {
+		if entryName(&file) != filename || d.isHidden(file) {
 			continue
 		}
 
-		if file.IsDir() {
-			return &Dir{
+		if isSymlinkFile(&file) {
+			return &Symlink{
 				fs:   d.fs,
 				File: &file,
 			}, nil
 		}
+		if file.IsDir() {
+			return &Dir{
+				fs:      d.fs,
+				File:    &file,
+				trashed: d.trashed,
+			}, nil
+		}
 		return &File{
 			fs:   d.fs,
 			File: &file,
 		}, nil
 	}
 
+	if !d.trashed {
+		if sub, ok := d.fs.lookupSubtitle(ctx, files, filename); ok {
+			return sub, nil
+		}
+	}
+
+	// "<name>.share" is the share control of the file, unless a file has
+	// that name.
+	if name := strings.TrimSuffix(filename, shareSuffix); name != filename {
+		for _, file := range files {
+			if entryName(&file) == name && !d.isHidden(file) {
+				return d.fs.shareFile(file), nil
+			}
+		}
+	}
+
+	// "<dir>.zip" is the zip of the directory, unless a file has that name.
+	if name := strings.TrimSuffix(filename, zipSuffix); name != filename {
+		for _, file := range files {
+			if file.Name == name && file.IsDir() && !d.isHidden(file) {
+				return &zipFile{fs: d.fs, dir: &file, zipBuild: d.fs.zips.get(file.ID)}, nil
+			}
+		}
+	}
+
 	return nil, fuse.ENOENT
 }
 
@@ -288,26 +824,43 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	d.fs.logger.Debugf("dir.ReadDirAll(%q)", d.Name)
 
-	files, err := d.fs.list(ctx, d.ID)
+	files, err := d.list(ctx)
 	if err != nil {
 		d.fs.logger.Printf("could not list directory %q: %v", d, err)
 		return nil, fuse.EIO
 	}
 
 	var entries []fuse.Dirent
+	if d.isRoot() && d.fs.trash != nil {
+		entries = append(entries, fuse.Dirent{Name: ".trash", Type: fuse.DT_Dir})
+	}
+
 	for _, file := range files {
+		if d.isHidden(file) {
+			continue
+		}
+
 		var dt fuse.DirentType
-		if file.IsDir() {
+		switch {
+		case isSymlinkFile(&file):
+			dt = fuse.DT_Link
+		case file.IsDir():
 			dt = fuse.DT_Dir
-		} else {
+		default:
 			dt = fuse.DT_File
 		}
 		entry := fuse.Dirent{
-			Name: file.Name,
+			Name: entryName(&file),
 			Type: dt,
 		}
 		entries = append(entries, entry)
 	}
+
+	if !d.trashed {
+		for _, name := range d.fs.subtitleNames(ctx, files) {
+			entries = append(entries, fuse.Dirent{Name: name, Type: fuse.DT_File})
+		}
+	}
 	return entries, nil
 }
 
@@ -322,16 +875,50 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 		return fuse.EIO
 	}
 
-	files, err := d.fs.list(ctx, d.ID)
+	files, err := d.list(ctx)
 	if err != nil {
 		d.fs.logger.Printf("could not list directory %q: %v", d, err)
 		return fuse.EIO
 	}
 
 	for _, file := range files {
-		if file.Name == filename {
-			return d.fs.remove(ctx, file.ID)
+		if entryName(&file) != filename {
+			continue
+		}
+
+		// Files.Delete removes directories recursively. rmdir must only
+		// succeed on empty directories, "rm -r" empties them beforehand.
+		if req.Dir {
+			if !file.IsDir() {
+				return fuse.Errno(syscall.ENOTDIR)
+			}
+
+			children, err := d.fs.list(ctx, file.ID)
+			if err != nil {
+				d.fs.logger.Printf("could not list directory %q: %v", file.Name, err)
+				return fuse.EIO
+			}
+			// lock files left in it do not count.
+			for _, child := range children {
+				if !isLockFile(&child) {
+					return fuse.Errno(syscall.ENOTEMPTY)
+				}
+			}
+		} else if file.IsDir() {
+			return fuse.Errno(syscall.EISDIR)
 		}
+
+		// deleting from the trash is permanent
+		if d.trashed {
+			err = d.fs.delete(ctx, file.ID)
+		} else {
+			err = d.fs.remove(ctx, file)
+		}
+		if err != nil {
+			return err
+		}
+		d.touch()
+		return nil
 	}
 
 	return fuse.ENOENT
@@ -353,7 +940,7 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Nod
 
 	d.fs.logger.Debugf("dir.Rename(old: %q, new: %q)", req.OldName, req.NewName)
 
-	files, err := d.fs.list(ctx, d.ID)
+	files, err := d.list(ctx)
 	if err != nil {
 		d.fs.logger.Printf("could not read directory %q: %v", d, err)
 		return fuse.EIO
@@ -361,8 +948,14 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Nod
 
 	fileid := int64(-1)
 	for _, file := range files {
-		if file.Name == oldname {
+		if entryName(&file) == oldname {
 			fileid = file.ID
+
+			// symbolic links keep their marker suffix on the remote.
+			if isSymlinkFile(&file) {
+				oldname = file.Name
+				newname += symlinkSuffix
+			}
 		}
 	}
 	if fileid < 0 {
@@ -385,13 +978,62 @@ func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Nod
 		d.fs.logger.Printf("could not move: %v", err)
 		return fuse.EIO
 	}
+
+	d.touch()
+	newdir.touch()
 	return nil
 }
 
+// Symlink implements fs.NodeSymlinker interface. Put.io has no symbolic
+// links, the link is stored as a file holding the target.
 func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
 	d.fs.logger.Debugf("dir.Symlink(src: %q, dst: %q)", req.NewName, req.Target)
 
-	return nil, fuse.ENOTSUP
+	files, err := d.list(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not list directory %q: %v", d, err)
+		return nil, fuse.EIO
+	}
+
+	for _, file := range files {
+		if entryName(&file) == req.NewName {
+			return nil, fuse.EEXIST
+		}
+	}
+
+	u, err := d.fs.upload(ctx, strings.NewReader(req.Target), req.NewName+symlinkSuffix, d.ID)
+	if err != nil {
+		d.fs.logger.Printf("could not create symlink on remote: %v", err)
+		return nil, fuse.EIO
+	}
+	if u.File == nil {
+		d.fs.logger.Printf("could not create symlink on remote")
+		return nil, fuse.EIO
+	}
+	d.touch()
+
+	return &Symlink{
+		fs:     d.fs,
+		File:   u.File,
+		target: req.Target,
+	}, nil
+}
+
+// isRoot reports whether the directory is the root of the mount.
+func (d *Dir) isRoot() bool {
+	return d.ID == 0
+}
+
+// isHidden reports whether the given child of the directory is used
+// internally by putiofs and should not be shown as is.
+func (d *Dir) isHidden(file putio.File) bool {
+	if isLockFile(&file) {
+		return true
+	}
+	if !d.isRoot() {
+		return false
+	}
+	return file.Name == trashFolderName || file.Name == versionsFolderName
 }
 
 func (d *Dir) rename(ctx context.Context, fileid int64, oldname, newname string) error {
@@ -426,16 +1068,25 @@ type File struct {
 	fs *FileSystem
 
 	*putio.File // metadata
+
+	// readonly files can not be opened for writing.
+	readonly bool
+
+	// mu guards handles.
+	mu sync.Mutex
+	// handles are the open handles of the file.
+	handles map[*fileHandle]struct{}
 }
 
 var (
-	_ fs.Node            = (*File)(nil)
-	_ fs.NodeOpener      = (*File)(nil)
-	_ fs.NodeFsyncer     = (*File)(nil)
-	_ fs.NodeGetxattrer  = (*File)(nil)
-	_ fs.NodeListxattrer = (*File)(nil)
-	_ fs.NodeSetxattrer  = (*File)(nil)
-	_ fs.NodeSetattrer   = (*File)(nil)
+	_ fs.Node              = (*File)(nil)
+	_ fs.NodeOpener        = (*File)(nil)
+	_ fs.NodeFsyncer       = (*File)(nil)
+	_ fs.NodeGetxattrer    = (*File)(nil)
+	_ fs.NodeListxattrer   = (*File)(nil)
+	_ fs.NodeSetxattrer    = (*File)(nil)
+	_ fs.NodeRemovexattrer = (*File)(nil)
+	_ fs.NodeSetattrer     = (*File)(nil)
 )
 
 func (f *File) String() string {
@@ -447,13 +1098,17 @@ func (f *File) String() string {
 func (f *File) Attr(ctx context.Context, attr *fuse.Attr) error {
 	f.fs.logger.Debugf("file.Attr(%q)", f.Name)
 
-	attr.Mode = 0644
-	attr.Uid = uint32(os.Getuid())
-	attr.Gid = uint32(os.Getgid())
+	f.fs.perms.file(attr, f.readonly)
 	attr.Size = uint64(f.Size)
 	attr.Ctime = f.CreatedAt.Time
 	attr.Mtime = f.CreatedAt.Time
 	attr.Crtime = f.CreatedAt.Time
+
+	if f.fs.meta != nil {
+		if m, ok := f.fs.meta.get(f.ID); ok {
+			m.apply(attr)
+		}
+	}
 	return nil
 }
 
@@ -463,7 +1118,43 @@ func (f *File) Attr(ctx context.Context, attr *fuse.Attr) error {
 func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
 	f.fs.logger.Debugf("file.Open(%q, flags: %v)", f.Name, req.Flags)
 
-	return f.newHandle()
+	writable := !req.Flags.IsReadOnly()
+	if f.readonly && writable {
+		return nil, fuse.EPERM
+	}
+
+	var lock *remoteLock
+	if writable && f.fs.lockFiles {
+		l, err := f.fs.lock(ctx, f.ParentID, f.Name)
+		if err == errLocked {
+			return nil, err
+		}
+		if err != nil {
+			f.fs.logger.Printf("could not lock %v: %v", f, err)
+			return nil, fuse.EIO
+		}
+		lock = l
+	}
+
+	h, err := f.newHandle(writable)
+	if err != nil {
+		if lock != nil {
+			f.fs.unlock(ctx, lock)
+		}
+		return nil, err
+	}
+	h.lock = lock
+
+	// the staging file is empty, uploading it truncates the remote file.
+	if req.Flags&fuse.OpenTruncate != 0 && writable {
+		f.fs.stagingMu.Lock()
+		h.dirty = true
+		h.size = 0
+		f.fs.stagingMu.Unlock()
+		h.base = 0
+		f.Size = 0
+	}
+	return h, nil
 }
 
 // Fsync implements the fs.NodeFsyncer interface. It is called to explicitly
@@ -477,35 +1168,92 @@ func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
 func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
 	f.fs.logger.Debugf("file.Setattr(%q)", f.Name)
 
+	if f.readonly {
+		return fuse.EPERM
+	}
+
 	if req.Valid.Size() {
-		f.Size = int64(req.Size)
+		err := f.truncate(ctx, int64(req.Size))
+		if err == errNoSpace {
+			return err
+		}
+		if err != nil {
+			f.fs.logger.Printf("could not truncate %v: %v", f, err)
+			return fuse.EIO
+		}
 	}
 
-	return nil
+	return f.fs.setattr(f.ID, req)
 }
 
+// truncate changes the size of the file. If the file is open for writing, the
+// staging files of its handles are resized and uploaded on the next flush.
+// Otherwise the resized content is uploaded right away.
+func (f *File) truncate(ctx context.Context, size int64) error {
+	f.mu.Lock()
+	var handles []*fileHandle
+	for h := range f.handles {
+		if h.writable {
+			handles = append(handles, h)
+		}
+	}
+	f.mu.Unlock()
+
+	if len(handles) > 0 {
+		for _, h := range handles {
+			if err := h.truncate(ctx, size); err != nil {
+				return err
+			}
+		}
+		f.Size = size
+		return nil
+	}
+
+	h, err := f.newHandle(true)
+	if err != nil {
+		return err
+	}
+	defer h.release()
+
+	if err := h.truncate(ctx, size); err != nil {
+		return err
+	}
+	return h.flush(ctx)
+}
+
+// Getxattr implements the fs.NodeGetxattrer interface. Put.io metadata is
+// exposed as user.putio.* attributes.
 func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, res *fuse.GetxattrResponse) error {
-	f.fs.logger.Debugf("file.Getxattr(%q)", f.Name)
-	return nil
+	f.fs.logger.Debugf("file.Getxattr(%q, %q)", f.Name, req.Name)
+
+	return f.fs.getxattr(ctx, f.File, req, res)
 }
 
+// Listxattr implements the fs.NodeListxattrer interface.
 func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, res *fuse.ListxattrResponse) error {
 	f.fs.logger.Debugf("file.Listxattr(%q)", f.Name)
+
+	f.fs.listxattr(f.File, res)
 	return nil
 }
 
+// Removexattr implements the fs.NodeRemovexattrer interface.
 func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
-	f.fs.logger.Debugf("file.Removexattr(%q)", f.Name)
-	return nil
+	f.fs.logger.Debugf("file.Removexattr(%q, %q)", f.Name, req.Name)
+
+	return f.fs.removexattr(ctx, f.ID, req)
 }
 
+// Setxattr implements the fs.NodeSetxattrer interface. User defined
+// attributes are kept in the metadata overlay.
 func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
-	f.fs.logger.Debugf("file.Setxattr(%q)", f.Name)
-	return nil
+	f.fs.logger.Debugf("file.Setxattr(%q, %q)", f.Name, req.Name)
+
+	return f.fs.setxattr(ctx, f.ID, req)
 }
 
-func (f *File) newHandle() (fs.Handle, error) {
-	tmp, err := ioutil.TempFile("", "putiofs-")
+func (f *File) newHandle(writable bool) (*fileHandle, error) {
+	tmp, err := ioutil.TempFile(f.fs.stagingDir, "putiofs-")
 	if err != nil {
 		f.fs.logger.Printf("could not open: %v", err)
 		return nil, fuse.EIO
@@ -513,10 +1261,30 @@ func (f *File) newHandle() (fs.Handle, error) {
 
 	f.fs.logger.Debugf("created %q for %v", tmp.Name(), f)
 
-	return &fileHandle{
-		f:   f,
-		tmp: tmp,
-	}, nil
+	h := &fileHandle{
+		f:          f,
+		tmp:        tmp,
+		writable:   writable,
+		base:       f.Size,
+		size:       f.Size,
+		remoteSize: f.Size,
+	}
+
+	f.mu.Lock()
+	if f.handles == nil {
+		f.handles = make(map[*fileHandle]struct{})
+	}
+	f.handles[h] = struct{}{}
+	f.mu.Unlock()
+
+	f.fs.stagingMu.Lock()
+	if f.fs.staging == nil {
+		f.fs.staging = make(map[*fileHandle]struct{})
+	}
+	f.fs.staging[h] = struct{}{}
+	f.fs.stagingMu.Unlock()
+
+	return h, nil
 }
 
 type fileHandle struct {
@@ -524,8 +1292,35 @@ type fileHandle struct {
 
 	// tmp stores the un-flushed file contents. When the handle is released,
 	// content is written to the remote.
-	tmp   *os.File
+	tmp *os.File
+
+	// dirty, size, remoteSize, local and uploading are guarded by
+	// f.fs.stagingMu.
 	dirty bool
+
+	// base is the size of the remote content backing the staging file.
+	// Ranges below base that are not staged yet are filled from the remote
+	// file before they are read or uploaded. stagedMu guards base, staged
+	// and the writes into tmp, so that a fill does not overwrite the data
+	// written meanwhile.
+	stagedMu sync.Mutex
+	base     int64
+	staged   extents
+
+	// size is the size of the staged content, remoteSize is the size of the
+	// remote file it replaces. They are used to check the quota.
+	size       int64
+	remoteSize int64
+
+	// local is the local disk space the staging file takes once filled.
+	local int64
+
+	uploading bool
+
+	// lock is the lock file held while the handle is open for writing.
+	lock *remoteLock
+
+	writable bool
 }
 
 var (
@@ -551,6 +1346,25 @@ func (h *fileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse
 		return nil
 	}
 
+	// the staging file holds the up to date content of a modified file, or
+	// the whole content of a file that is not on Put.io.
+	if h.isDirty() || h.f.ID < 0 {
+		end := req.Offset + int64(req.Size)
+		if err := h.fill(ctx, req.Offset, end); err != nil {
+			h.f.fs.logger.Printf("could not fill staged file %q: %v", h.f, err)
+			return fuse.EIO
+		}
+
+		buf := make([]byte, minInt64(int64(req.Size), h.f.Size-req.Offset))
+		n, err := h.tmp.ReadAt(buf, req.Offset)
+		if err != nil && err != io.EOF {
+			h.f.fs.logger.Printf("could not read staged file %q: %v", h.f, err)
+			return fuse.EIO
+		}
+		resp.Data = buf[:n]
+		return nil
+	}
+
 	body, err := h.f.fs.download(ctx, h.f.ID, req.Offset, req.Size)
 	if err != nil {
 		h.f.fs.logger.Printf("could not download %v-%v: %v", h.f.ID, h.f.Name, err)
@@ -587,70 +1401,219 @@ func (h *fileHandle) Write(ctx context.Context, req *fuse.WriteRequest, res *fus
 		return fuse.EIO
 	}
 
+	// a modified file gets a new mtime.
+	if !h.isDirty() && h.f.ID >= 0 && h.f.fs.meta != nil {
+		h.f.fs.meta.update(h.f.ID, func(m *fileMeta) {
+			m.Mtime = nil
+		})
+	}
+
+	// the first write makes the whole file staged locally.
+	end := req.Offset + int64(len(req.Data))
+	h.f.fs.stagingMu.Lock()
+	size, grow := maxInt64(end, h.size), end > h.size || !h.dirty
+	h.f.fs.stagingMu.Unlock()
+	if grow {
+		if err := h.f.fs.reserve(ctx, h, size); err != nil {
+			return err
+		}
+	}
+
+	h.stagedMu.Lock()
 	n, err := h.tmp.WriteAt(req.Data, req.Offset)
+	end = req.Offset + int64(n)
+	h.staged = h.staged.add(req.Offset, end)
+	h.stagedMu.Unlock()
 	if err != nil {
 		h.f.fs.logger.Printf("fileHandle.Write: %v", err)
 		return fuse.EIO
 	}
 	res.Size = n
-	h.dirty = true
+	h.setDirty(true)
+
+	if end > h.f.Size {
+		h.f.Size = end
+	}
+	return nil
+}
+
+// truncate resizes the staging file and marks the handle dirty. Extended
+// ranges read as zeros.
+func (h *fileHandle) truncate(ctx context.Context, size int64) error {
+	if err := h.f.fs.reserve(ctx, h, size); err != nil {
+		return err
+	}
+
+	h.stagedMu.Lock()
+	defer h.stagedMu.Unlock()
+
+	if err := h.tmp.Truncate(size); err != nil {
+		return err
+	}
+	if size < h.base {
+		h.base = size
+	}
+	h.staged = h.staged.clip(size)
+	h.setDirty(true)
 	return nil
 }
 
 func (h *fileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
 	h.f.fs.logger.Debugf("fileHandle.Flush(%q)", h.f.Name)
+	return h.flush(ctx)
+}
 
+// flush uploads the staging file if it has been modified.
+func (h *fileHandle) flush(ctx context.Context) error {
 	if h.tmp == nil {
 		h.f.fs.logger.Printf("Flush called on filehandle without a tempfile set")
 		return fuse.EIO
 	}
 
-	if !h.dirty {
+	if !h.isDirty() {
 		return nil
 	}
 
+	// fill clips the range to what is backed by the remote file.
+	if err := h.fill(ctx, 0, math.MaxInt64); err != nil {
+		h.f.fs.logger.Printf("could not fill staged file %q: %v", h.f, err)
+		return fuse.EIO
+	}
+
 	_, err := h.tmp.Seek(0, 0)
 	if err != nil {
 		h.f.fs.logger.Printf("fileHandle.Flush: %v", err)
 		return fuse.EIO
 	}
 
+	if isLinkName(h.f.Name) {
+		err := h.addLinks(ctx)
+		h.setDirty(false)
+		if err != nil {
+			h.f.fs.logger.Printf("%v", err)
+			return fuse.EIO
+		}
+		return nil
+	}
+
+	// the new file gets a new ID, carry the metadata over.
+	var meta fileMeta
+	if h.f.fs.meta != nil {
+		meta, _ = h.f.fs.meta.get(h.f.ID)
+	}
+
 	// remove the file first because Upload will create a new file even though
 	// the file exists. that's how Putio works.
-	if err := h.f.fs.putio.Files.Delete(ctx, h.f.ID); err != nil {
-		h.f.fs.logger.Printf("could not delete file %v: %v", h.f.File, err)
-		return fuse.EIO
+	if h.f.ID >= 0 {
+		if err := h.f.fs.supersede(ctx, h.f.File, h.remoteSize); err != nil {
+			h.f.fs.logger.Printf("could not delete file %v: %v", h.f.File, err)
+			return fuse.EIO
+		}
 	}
 
-	u, err := h.f.fs.putio.Files.Upload(ctx, h.tmp, h.f.Name, h.f.ParentID)
+	h.setUploading(true)
+	u, err := h.f.fs.upload(ctx, h.tmp, h.f.Name, h.f.ParentID)
+	h.setUploading(false)
 	if err != nil {
 		h.f.fs.logger.Printf("could not upload: %v", err)
+		if isTorrentName(h.f.Name) {
+			h.f.fs.transferResults.add(h.f.ParentID, fmt.Sprintf("✗ %v: %v", h.f.Name, err))
+		}
 		return fuse.EIO
 	}
 
-	if u.File == nil {
+	switch {
+	case u.Transfer != nil:
+		size := h.f.Size
+		h.f.ID = -1
+		if err := h.torrentAdded(ctx, u.Transfer); err != nil {
+			h.f.fs.logger.Printf("could not keep torrent file %q: %v", h.f.Name, err)
+		}
+		h.f.Size = size
+	case u.File != nil:
+		*h.f.File = *u.File
+	default:
 		h.f.fs.logger.Printf("could not create new file on remote")
 		return fuse.EIO
 	}
 
-	*h.f.File = *u.File
+	if h.f.ID >= 0 && h.f.fs.meta != nil {
+		h.f.fs.meta.set(h.f.ID, meta)
+	}
+
+	h.f.fs.stagingMu.Lock()
 	h.dirty = false
+	h.remoteSize = h.f.Size
+	h.f.fs.stagingMu.Unlock()
+
+	// the staging file is complete now, nothing needs to be filled from the
+	// new remote file.
+	h.stagedMu.Lock()
+	h.base = 0
+	h.stagedMu.Unlock()
 
 	return nil
 }
 
+// isDirty reports whether the staging file has changes that are not uploaded
+// yet.
+func (h *fileHandle) isDirty() bool {
+	h.f.fs.stagingMu.Lock()
+	defer h.f.fs.stagingMu.Unlock()
+	return h.dirty
+}
+
+func (h *fileHandle) setDirty(dirty bool) {
+	h.f.fs.stagingMu.Lock()
+	h.dirty = dirty
+	h.f.fs.stagingMu.Unlock()
+}
+
+func (h *fileHandle) setUploading(uploading bool) {
+	h.f.fs.stagingMu.Lock()
+	h.uploading = uploading
+	h.f.fs.stagingMu.Unlock()
+}
+
 // Release implements the fs.HandleReleaser interface. It is called when all
 // file descriptors to the file have been closed.
 func (h *fileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
 	h.f.fs.logger.Debugf("fileHandle.Release(%q)", h.f.Name)
 
+	h.release()
+	if h.lock != nil {
+		h.f.fs.unlock(ctx, h.lock)
+	}
+	return nil
+}
+
+// release removes the staging file and forgets the handle.
+func (h *fileHandle) release() {
+	h.f.mu.Lock()
+	delete(h.f.handles, h)
+	h.f.mu.Unlock()
+
+	h.f.fs.stagingMu.Lock()
+	delete(h.f.fs.staging, h)
+	if h.local > 0 {
+		h.f.fs.notifyStagingFreedLocked()
+	}
+	h.f.fs.stagingMu.Unlock()
+
 	h.tmp.Close()
 	os.Remove(h.tmp.Name())
 	h.tmp = nil
-	return nil
 }
 
-type staticFileNode string
+// staticFileNode is a read-only pseudo file with fixed content.
+type staticFileNode struct {
+	fs      *FileSystem
+	content string
+}
+
+func (f *FileSystem) staticFile(content string) staticFileNode {
+	return staticFileNode{fs: f, content: content}
+}
 
 var (
 	_ fs.Node         = (*staticFileNode)(nil)
@@ -662,9 +1625,9 @@ var (
 // attribute for this static file.
 func (s staticFileNode) Attr(ctx context.Context, attr *fuse.Attr) error {
 	attr.Mode = 0400
-	attr.Uid = uint32(os.Getuid())
-	attr.Gid = uint32(os.Getgid())
-	attr.Size = uint64(len(s))
+	attr.Uid = s.fs.perms.uid
+	attr.Gid = s.fs.perms.gid
+	attr.Size = uint64(len(s.content))
 	return nil
 }
 
@@ -675,7 +1638,7 @@ func (f staticFileNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *f
 }
 
 func (s staticFileNode) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	fuseutil.HandleRead(req, resp, []byte(s))
+	fuseutil.HandleRead(req, resp, []byte(s.content))
 	return nil
 }
 
@@ -726,6 +1689,20 @@ func humanizeBytes(s uint64) string {
 	return fmt.Sprintf(f, val, suffix)
 }
 
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 var junkFilePrefixes = []string{
 	// macOS stuff
 	"._",
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+func TestDirAttrLinks(t *testing.T) {
+	fsys := &FileSystem{logger: NewLogger("putiofs: ", false)}
+	created := time.Date(2018, 3, 14, 12, 0, 0, 0, time.UTC)
+	newer := created.Add(time.Hour)
+
+	attr := func() fuse.Attr {
+		// every lookup makes a new node.
+		d := &Dir{fs: fsys, File: &putio.File{ID: 7, CreatedAt: &putio.Time{Time: created}}}
+		var attr fuse.Attr
+		if err := d.Attr(context.Background(), &attr); err != nil {
+			t.Fatal(err)
+		}
+		return attr
+	}
+
+	if got := attr(); got.Nlink != 1 || !got.Mtime.Equal(created) {
+		t.Errorf("unlisted directory: nlink %v, mtime %v, want 1, %v", got.Nlink, got.Mtime, created)
+	}
+
+	fsys.updateDirStat(7, func(st *dirStat) {
+		st.listed = true
+		st.subdirs = 3
+		st.mtime = newer
+	})
+	if got := attr(); got.Nlink != 5 || !got.Mtime.Equal(newer) {
+		t.Errorf("listed directory: nlink %v, mtime %v, want 5, %v", got.Nlink, got.Mtime, newer)
+	}
+
+	fsys.updateDirStat(7, func(st *dirStat) { st.subdirs = 0 })
+	if got := attr(); got.Nlink != 2 {
+		t.Errorf("listed leaf directory: nlink %v, want 2", got.Nlink)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Files written with the .magnet or .url extension are not uploaded. Their
+// links are added as transfers into the directory they are written in, which
+// makes the mount usable as a blackhole folder by tools that can only save
+// files. The outcome is written into a status file next to them.
+
+const (
+	// linkStatusSuffix is appended to the name of a link file to get the
+	// name of its status file.
+	linkStatusSuffix = ".status"
+
+	// maxLinkFileSize is the size of a link file that is read for links.
+	// Anything after it is ignored.
+	maxLinkFileSize = 64 << 10
+)
+
+// isLinkName reports whether a file with the given name is a link file.
+func isLinkName(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".magnet", ".url":
+		return true
+	}
+	return false
+}
+
+// isLocalName reports whether a file with the given name is kept locally
+// while it is written, instead of being created on Put.io right away.
+func isLocalName(name string) bool {
+	return isTorrentName(name) || isLinkName(name)
+}
+
+// parseLinks returns the links in the content of a link file. It is either
+// one link per line or an Internet Shortcut, where the link is the URL key.
+func parseLinks(r io.Reader) ([]string, error) {
+	var links []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"), strings.HasPrefix(line, "["):
+			continue
+		case strings.HasPrefix(strings.ToUpper(line), "URL="):
+			line = strings.TrimSpace(line[len("URL="):])
+		case strings.Contains(line, "=") && !strings.Contains(line, ":"):
+			// other Internet Shortcut keys.
+			continue
+		}
+		if line != "" {
+			links = append(links, line)
+		}
+	}
+	return links, scanner.Err()
+}
+
+// addLinks adds the links in the staged link file as transfers into its
+// directory and writes the outcome into the status file.
+func (h *fileHandle) addLinks(ctx context.Context) error {
+	fsys := h.f.fs
+
+	if _, err := h.tmp.Seek(0, 0); err != nil {
+		return err
+	}
+	links, err := parseLinks(io.LimitReader(h.tmp, maxLinkFileSize))
+
+	var status bytes.Buffer
+	var failed bool
+	if err != nil {
+		failed = true
+		fmt.Fprintf(&status, "✗ could not read the links: %v\n", err)
+	}
+	for _, link := range links {
+		t, err := fsys.addTransfer(ctx, h.f.ParentID, link)
+		if err != nil {
+			failed = true
+			fmt.Fprintf(&status, "✗ %v: %v\n", link, err)
+			continue
+		}
+		fmt.Fprintf(&status, "✓ %v: %v\n", link, t.Name)
+	}
+	if len(links) == 0 && err == nil {
+		failed = true
+		status.WriteString("✗ no links found\n")
+	}
+
+	if err := fsys.writeStatus(ctx, h.f.ParentID, h.f.Name+linkStatusSuffix, status.Bytes()); err != nil {
+		fsys.logger.Printf("could not write status of %q: %v", h.f.Name, err)
+	}
+
+	if failed {
+		return fmt.Errorf("could not add the links of %q", h.f.Name)
+	}
+	return nil
+}
+
+// writeStatus replaces the file named name in parent with the given content.
+func (f *FileSystem) writeStatus(ctx context.Context, parent int64, name string, content []byte) error {
+	files, err := f.list(ctx, parent)
+	if err != nil {
+		return err
+	}
+
+	var old []int64
+	for _, file := range files {
+		if file.Name == name && !file.IsDir() {
+			old = append(old, file.ID)
+		}
+	}
+	if len(old) > 0 {
+		if err := f.delete(ctx, old...); err != nil {
+			return err
+		}
+	}
+
+	_, err = f.upload(ctx, bytes.NewReader(content), name, parent)
+	return err
+}
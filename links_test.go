@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"", nil},
+		{"magnet:?xt=urn:btih:abc\n", []string{"magnet:?xt=urn:btih:abc"}},
+		{
+			"  http://example.com/a.iso  \n\n# comment\nhttp://example.com/b.iso",
+			[]string{"http://example.com/a.iso", "http://example.com/b.iso"},
+		},
+		{
+			"[InternetShortcut]\r\nURL=http://example.com/a.iso\r\nIconIndex=0\r\n",
+			[]string{"http://example.com/a.iso"},
+		},
+		{"[InternetShortcut]\nurl=http://example.com/a.iso\n", []string{"http://example.com/a.iso"}},
+		{"; comment\nURL=\n", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := parseLinks(strings.NewReader(tt.content))
+		if err != nil {
+			t.Errorf("parseLinks(%q) failed: %v", tt.content, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLinks(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestParseLinksLongLine(t *testing.T) {
+	content := "http://example.com/a.iso\n" + strings.Repeat("x", 128<<10) + "\n"
+	if _, err := parseLinks(strings.NewReader(content)); err == nil {
+		t.Errorf("parseLinks of a line longer than the scanner buffer did not fail")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// Advisory flock(2) and fcntl(2) locks are handled by the kernel: putiofs does
+// not claim lock support in the FUSE handshake, so the kernel keeps the locks
+// of the processes using the mount locally. They do not reach other machines
+// though. For that, putiofs can leave lock files on Put.io while a file is
+// open for writing, so that other putiofs instances sharing the account
+// refuse to edit it at the same time.
+
+const (
+	// lockSuffix marks the lock files. The lock file of "name" is
+	// ".name.putiofs-lock" in the same folder.
+	lockSuffix = ".putiofs-lock"
+
+	// lockStaleAfter is how long a lock file is honored. Locks left behind by
+	// crashed instances expire after that.
+	lockStaleAfter = 12 * time.Hour
+)
+
+// errLocked is returned when a file is being edited by another instance.
+var errLocked = fuse.Errno(syscall.EBUSY)
+
+// lockInfo is the content of a lock file.
+type lockInfo struct {
+	Owner string    `json:"owner"`
+	Host  string    `json:"host"`
+	PID   int       `json:"pid"`
+	Since time.Time `json:"since"`
+}
+
+// remoteLock is a lock file held by this instance.
+type remoteLock struct {
+	key  string
+	id   int64
+	refs int
+}
+
+func lockFileName(name string) string {
+	return "." + name + lockSuffix
+}
+
+// isLockFile reports whether the file on Put.io is a lock file.
+func isLockFile(file *putio.File) bool {
+	return !file.IsDir() && strings.HasPrefix(file.Name, ".") && strings.HasSuffix(file.Name, lockSuffix)
+}
+
+// newInstanceID returns a random identifier for this putiofs instance.
+func newInstanceID() string {
+	host, _ := os.Hostname()
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return fmt.Sprintf("%v-%v-%x", host, os.Getpid(), r.Int63())
+}
+
+// lock acquires the lock file of the file named name in parent. Locks held by
+// this instance are shared between its handles.
+func (f *FileSystem) lock(ctx context.Context, parent int64, name string) (*remoteLock, error) {
+	key := fmt.Sprintf("%v/%v", parent, name)
+
+	f.lockKey(key)
+	defer f.unlockKey(key)
+
+	f.locksMu.Lock()
+	l, ok := f.locks[key]
+	if ok {
+		l.refs++
+	}
+	f.locksMu.Unlock()
+	if ok {
+		return l, nil
+	}
+
+	if err := f.checkLock(ctx, parent, name, -1); err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	info, _ := json.Marshal(lockInfo{
+		Owner: f.instance,
+		Host:  host,
+		PID:   os.Getpid(),
+		Since: time.Now(),
+	})
+	u, err := f.putio.Files.Upload(ctx, bytes.NewReader(info), lockFileName(name), parent)
+	if err != nil {
+		return nil, fmt.Errorf("could not create lock file: %v", err)
+	}
+	if u.File == nil {
+		return nil, fmt.Errorf("could not create lock file")
+	}
+
+	// two instances may have created their lock files at the same time.
+	// the older one wins.
+	if err := f.checkLock(ctx, parent, name, u.File.ID); err != nil {
+		f.putio.Files.Delete(ctx, u.File.ID)
+		return nil, err
+	}
+
+	l = &remoteLock{key: key, id: u.File.ID, refs: 1}
+	f.locksMu.Lock()
+	f.locks[key] = l
+	f.locksMu.Unlock()
+	return l, nil
+}
+
+// keyMutex serializes the locking and unlocking of a file, so that the
+// round-trips to Put.io for one file do not hold up the others. users counts
+// the goroutines holding or waiting for it.
+type keyMutex struct {
+	sync.Mutex
+	users int
+}
+
+// lockKey locks the mutex of the file with the given key.
+func (f *FileSystem) lockKey(key string) {
+	f.locksMu.Lock()
+	mu, ok := f.lockMus[key]
+	if !ok {
+		mu = new(keyMutex)
+		f.lockMus[key] = mu
+	}
+	mu.users++
+	f.locksMu.Unlock()
+
+	mu.Lock()
+}
+
+// unlockKey unlocks the mutex of the file with the given key. It is dropped
+// once nobody uses it.
+func (f *FileSystem) unlockKey(key string) {
+	f.locksMu.Lock()
+	defer f.locksMu.Unlock()
+
+	mu := f.lockMus[key]
+	mu.Unlock()
+	mu.users--
+	if mu.users == 0 {
+		delete(f.lockMus, key)
+	}
+}
+
+// checkLock fails with EBUSY if another instance holds a valid lock on the
+// file named name in parent. The lock file with the given ID is ours, lock
+// files created after it are ignored.
+func (f *FileSystem) checkLock(ctx context.Context, parent int64, name string, ours int64) error {
+	files, err := f.list(ctx, parent)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Name != lockFileName(name) || file.ID == ours {
+			continue
+		}
+		if ours >= 0 && file.ID > ours {
+			continue
+		}
+
+		info, err := f.readLock(ctx, file)
+		if err != nil {
+			f.logger.Printf("could not read lock file %q: %v", file.Name, err)
+			continue
+		}
+		if time.Since(info.Since) > lockStaleAfter {
+			f.logger.Debugf("ignoring stale lock on %q held by %v", name, info.Owner)
+			continue
+		}
+		if info.Owner != f.instance {
+			f.logger.Printf("%q is being edited on %v (pid %v) since %v", name, info.Host, info.PID, info.Since)
+			return errLocked
+		}
+	}
+	return nil
+}
+
+func (f *FileSystem) readLock(ctx context.Context, file putio.File) (lockInfo, error) {
+	var info lockInfo
+	if file.Size == 0 {
+		return info, fmt.Errorf("empty lock file")
+	}
+
+	body, err := f.download(ctx, file.ID, 0, int(file.Size))
+	if err != nil {
+		return info, err
+	}
+	defer body.Close()
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+// unlock releases a lock acquired with lock. The lock file is deleted once
+// the last handle using it is released.
+func (f *FileSystem) unlock(ctx context.Context, l *remoteLock) {
+	f.lockKey(l.key)
+	defer f.unlockKey(l.key)
+
+	f.locksMu.Lock()
+	l.refs--
+	last := l.refs == 0
+	if last {
+		delete(f.locks, l.key)
+	}
+	f.locksMu.Unlock()
+	if !last {
+		return
+	}
+
+	if err := f.putio.Files.Delete(ctx, l.id); err != nil {
+		f.logger.Printf("could not delete lock file %v: %v", l.id, err)
+	}
+}
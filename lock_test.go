@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLockKey(t *testing.T) {
+	fsys := &FileSystem{lockMus: make(map[string]*keyMutex)}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	held := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		key := []string{"1/a", "1/b", "2/a"}[i%3]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fsys.lockKey(key)
+			defer fsys.unlockKey(key)
+
+			mu.Lock()
+			if held[key] {
+				t.Errorf("%q is locked twice", key)
+			}
+			held[key] = true
+			mu.Unlock()
+
+			mu.Lock()
+			held[key] = false
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if n := len(fsys.lockMus); n != 0 {
+		t.Errorf("%v mutexes are left after unlocking", n)
+	}
+}
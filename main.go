@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -18,7 +21,32 @@ func main() {
 		token    = flag.String("token", "", "personal access token")
 		debug    = flag.Bool("debug", false, "debug mode")
 		readonly = flag.Bool("readonly", false, "mount filesystem read-only")
+
+		uid                = flag.Int("uid", -1, "owner of the files (default is the current user)")
+		gid                = flag.Int("gid", -1, "group of the files (default is the current group)")
+		umask              = modeFlag(0)
+		dirMode            = modeFlag(0755)
+		fileMode           = modeFlag(0644)
+		allowOther         = flag.Bool("allow-other", false, "allow other users to access the mount (FUSE allow_other)")
+		defaultPermissions = flag.Bool("default-permissions", false, "let the kernel enforce the permission bits (FUSE default_permissions)")
+
+		trash          = flag.Bool("trash", false, "move deleted files to .trash instead of deleting them")
+		trashRetention = flag.Duration("trash-retention", 30*24*time.Hour, "how long to keep files in .trash (0 keeps them forever)")
+		versions       = flag.Int("versions", 0, "number of previous versions to keep in .versions when a file is overwritten")
+
+		stagingDir   = flag.String("staging-dir", "", "directory to stage written files in before upload (default is the system temp dir)")
+		stagingLimit = flag.Int64("staging-limit", 0, "maximum total size of staged files in bytes (0 is unlimited)")
+		stagingBlock = flag.Bool("staging-block", false, "block writes until staging space is freed instead of failing with ENOSPC")
+		metadataFile = flag.String("metadata-file", "", "file to keep chmod, chown, mtime and xattr changes in (they are ignored if empty)")
+
+		lockFiles = flag.Bool("lock-files", false, "lock files opened for writing against other putiofs instances using lock files on Put.io")
+
+		keepTorrents = flag.Bool("keep-torrents", false, "keep .torrent files written into the mount on Put.io besides starting their transfers")
+		subtitles    = flag.String("subtitles", "", "comma separated subtitle formats (srt, vtt) to present next to videos (none if empty)")
 	)
+	flag.Var(&umask, "umask", "octal permission bits to clear from every file")
+	flag.Var(&dirMode, "dir-mode", "octal permission bits of directories")
+	flag.Var(&fileMode, "file-mode", "octal permission bits of files")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -42,6 +70,40 @@ func main() {
 	if *readonly {
 		mountOpts = append(mountOpts, fuse.ReadOnly())
 	}
+	if *allowOther {
+		mountOpts = append(mountOpts, fuse.AllowOther())
+	}
+	if *defaultPermissions {
+		mountOpts = append(mountOpts, fuse.DefaultPermissions())
+	}
+
+	if *stagingDir != "" {
+		if err := os.MkdirAll(*stagingDir, 0700); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	filesys, err := NewFileSystem(*token, Options{
+		Debug:          *debug,
+		Uid:            *uid,
+		Gid:            *gid,
+		Umask:          os.FileMode(umask),
+		DirMode:        os.FileMode(dirMode),
+		FileMode:       os.FileMode(fileMode),
+		MetadataFile:   *metadataFile,
+		Trash:          *trash,
+		TrashRetention: *trashRetention,
+		StagingDir:     *stagingDir,
+		StagingLimit:   *stagingLimit,
+		StagingBlock:   *stagingBlock,
+		Versions:       *versions,
+		LockFiles:      *lockFiles,
+		KeepTorrents:   *keepTorrents,
+		Subtitles:      subtitleFormatsFlag(*subtitles),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	conn, err := fuse.Mount(flag.Arg(0), mountOpts...)
 	if err != nil {
@@ -49,8 +111,10 @@ func main() {
 	}
 	defer conn.Close()
 
-	filesys := NewFileSystem(*token, *debug)
 	err = fs.Serve(conn, filesys)
+	if cerr := filesys.Close(); cerr != nil {
+		log.Print(cerr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -61,6 +125,36 @@ func main() {
 	}
 }
 
+// modeFlag is a flag.Value for octal permission bits.
+type modeFlag os.FileMode
+
+func (m *modeFlag) String() string {
+	return fmt.Sprintf("%#o", uint32(*m))
+}
+
+func (m *modeFlag) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid octal mode %q", s)
+	}
+	if v&^0777 != 0 {
+		return fmt.Errorf("mode %q has bits other than permission bits", s)
+	}
+	*m = modeFlag(v)
+	return nil
+}
+
+// subtitleFormatsFlag splits the comma separated formats of -subtitles.
+func subtitleFormatsFlag(s string) []string {
+	var formats []string
+	for _, format := range strings.Split(s, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
 func usage() {
 	fmt.Fprintln(os.Stderr, "Usage of putiofs:")
 	fmt.Fprintln(os.Stderr, "putiofs -token <YOUR TOKEN> <mountpoint>")
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestModeFlag(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"644", 0644, false},
+		{"0644", 0644, false},
+		{"0777", 0777, false},
+		{"0", 0, false},
+		{"1777", 0, true},
+		{"4755", 0, true},
+		{"0800", 0, true},
+		{"rw-r--r--", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		var m modeFlag
+		err := m.Set(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err == nil && os.FileMode(m) != tt.want {
+			t.Errorf("Set(%q) = %#o, want %#o", tt.s, uint32(m), uint32(tt.want))
+		}
+	}
+}
+
+func TestModeFlagString(t *testing.T) {
+	m := modeFlag(0640)
+	if got, want := m.String(), "0640"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+)
+
+// fileMeta is the metadata of a file that Put.io can not store. Unset fields
+// are reported as usual.
+type fileMeta struct {
+	Mode  *os.FileMode `json:"mode,omitempty"`
+	Uid   *uint32      `json:"uid,omitempty"`
+	Gid   *uint32      `json:"gid,omitempty"`
+	Mtime *time.Time   `json:"mtime,omitempty"`
+
+	// Xattrs are the user defined extended attributes.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+}
+
+func (m fileMeta) isZero() bool {
+	return m.Mode == nil && m.Uid == nil && m.Gid == nil && m.Mtime == nil && len(m.Xattrs) == 0
+}
+
+// metaSaveDelay is how long changes to the overlay are batched before it is
+// written to disk. Copying a tree changes the metadata of every file in it.
+const metaSaveDelay = time.Second
+
+// metaStore is a local overlay of file metadata, keyed by Put.io file ID. It
+// is persisted as a JSON file so that it survives remounts.
+type metaStore struct {
+	path   string
+	logger *Logger
+
+	mu    sync.Mutex
+	files map[int64]fileMeta
+
+	// saving is the timer of the pending save, nil if there are no unsaved
+	// changes.
+	saving *time.Timer
+}
+
+// openMetaStore loads the overlay stored at path. A missing file is an empty
+// overlay.
+func openMetaStore(path string, logger *Logger) (*metaStore, error) {
+	s := &metaStore{
+		path:   path,
+		logger: logger,
+		files:  make(map[int64]fileMeta),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.files); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// get returns the metadata of the given file.
+func (s *metaStore) get(id int64) (fileMeta, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.files[id]
+	return m, ok
+}
+
+// set replaces the metadata of the given file.
+func (s *metaStore) set(id int64, m fileMeta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.isZero() {
+		delete(s.files, id)
+	} else {
+		s.files[id] = m
+	}
+	s.changedLocked()
+}
+
+// update changes the metadata of the given file with fn.
+func (s *metaStore) update(id int64, fn func(*fileMeta)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.files[id]
+	fn(&m)
+	if m.isZero() {
+		delete(s.files, id)
+	} else {
+		s.files[id] = m
+	}
+	s.changedLocked()
+}
+
+// forget drops the metadata of the given files.
+func (s *metaStore) forget(ids ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changed bool
+	for _, id := range ids {
+		if _, ok := s.files[id]; ok {
+			delete(s.files, id)
+			changed = true
+		}
+	}
+	if changed {
+		s.changedLocked()
+	}
+}
+
+// changedLocked schedules a save of the overlay, unless one is pending
+// already. The caller must hold s.mu.
+func (s *metaStore) changedLocked() {
+	if s.saving == nil {
+		s.saving = time.AfterFunc(metaSaveDelay, s.save)
+	}
+}
+
+func (s *metaStore) save() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saving = nil
+	if err := s.saveLocked(); err != nil {
+		s.logger.Printf("could not save metadata: %v", err)
+	}
+}
+
+// sync writes the pending changes to disk right away.
+func (s *metaStore) sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saving == nil {
+		return nil
+	}
+	s.saving.Stop()
+	s.saving = nil
+	return s.saveLocked()
+}
+
+// saveLocked writes the overlay to disk atomically. The caller must hold
+// s.mu.
+func (s *metaStore) saveLocked() error {
+	b, err := json.Marshal(s.files)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), ".putiofs-meta-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// apply overrides the attributes with the stored metadata.
+func (m fileMeta) apply(attr *fuse.Attr) {
+	if m.Mode != nil {
+		attr.Mode = attr.Mode&os.ModeType | m.Mode.Perm()
+	}
+	if m.Uid != nil {
+		attr.Uid = *m.Uid
+	}
+	if m.Gid != nil {
+		attr.Gid = *m.Gid
+	}
+	if m.Mtime != nil {
+		attr.Mtime = *m.Mtime
+	}
+}
+
+// setattr records the mode, owner and mtime changes of req in m.
+func (m *fileMeta) setattr(req *fuse.SetattrRequest) {
+	if req.Valid.Mode() {
+		mode := req.Mode.Perm()
+		m.Mode = &mode
+	}
+	if req.Valid.Uid() {
+		uid := req.Uid
+		m.Uid = &uid
+	}
+	if req.Valid.Gid() {
+		gid := req.Gid
+		m.Gid = &gid
+	}
+	if req.Valid.MtimeNow() {
+		now := time.Now()
+		m.Mtime = &now
+	} else if req.Valid.Mtime() {
+		mtime := req.Mtime
+		m.Mtime = &mtime
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetaStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putiofs-meta-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "meta.json")
+
+	logger := NewLogger("putiofs: ", false)
+	s, err := openMetaStore(path, logger)
+	if err != nil {
+		t.Fatalf("could not open missing store: %v", err)
+	}
+
+	mode := os.FileMode(0600)
+	uid := uint32(1000)
+	mtime := time.Date(2018, 3, 14, 12, 0, 0, 0, time.UTC)
+	files := map[int64]fileMeta{
+		1: {Mode: &mode},
+		2: {Uid: &uid, Gid: &uid},
+		3: {Mtime: &mtime},
+	}
+	for id, m := range files {
+		s.set(id, m)
+	}
+	s.update(2, func(m *fileMeta) { m.Gid = nil })
+	s.forget(3)
+	s.set(4, fileMeta{})
+	if err := s.sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	s, err = openMetaStore(path, logger)
+	if err != nil {
+		t.Fatalf("could not reopen store: %v", err)
+	}
+	want := map[int64]fileMeta{
+		1: {Mode: &mode},
+		2: {Uid: &uid},
+	}
+	for id := int64(1); id <= 4; id++ {
+		got, ok := s.get(id)
+		w, wantOK := want[id]
+		if ok != wantOK || !reflect.DeepEqual(got, w) {
+			t.Errorf("get(%v) = %+v, %v, want %+v, %v", id, got, ok, w, wantOK)
+		}
+	}
+}
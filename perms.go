@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+
+	"bazil.org/fuse"
+)
+
+// perms maps the ownership and permission bits reported for the files in the
+// mount. Put.io has no notion of either.
+type perms struct {
+	uid, gid uint32
+
+	// dirMode and fileMode are the permission bits of directories and
+	// files, before umask is applied.
+	dirMode  os.FileMode
+	fileMode os.FileMode
+	umask    os.FileMode
+}
+
+func newPerms(opts Options) perms {
+	p := perms{
+		uid:      uint32(os.Getuid()),
+		gid:      uint32(os.Getgid()),
+		dirMode:  0755,
+		fileMode: 0644,
+		umask:    opts.Umask,
+	}
+	if opts.Uid >= 0 {
+		p.uid = uint32(opts.Uid)
+	}
+	if opts.Gid >= 0 {
+		p.gid = uint32(opts.Gid)
+	}
+	if opts.DirMode != 0 {
+		p.dirMode = opts.DirMode.Perm()
+	}
+	if opts.FileMode != 0 {
+		p.fileMode = opts.FileMode.Perm()
+	}
+	return p
+}
+
+// dir fills the owner and mode of a directory. Read-only directories have
+// their write bits cleared.
+func (p perms) dir(attr *fuse.Attr, readonly bool) {
+	mode := p.dirMode &^ p.umask
+	if readonly {
+		mode &^= 0222
+	}
+	attr.Mode = os.ModeDir | mode
+	attr.Uid = p.uid
+	attr.Gid = p.gid
+}
+
+// file fills the owner and mode of a regular file. Read-only files have their
+// write bits cleared.
+func (p perms) file(attr *fuse.Attr, readonly bool) {
+	mode := p.fileMode &^ p.umask
+	if readonly {
+		mode &^= 0222
+	}
+	attr.Mode = mode
+	attr.Uid = p.uid
+	attr.Gid = p.gid
+}
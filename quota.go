@@ -0,0 +1,82 @@
+package main
+
+import (
+	"syscall"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// errNoSpace is returned when a write does not fit in the remaining quota of
+// the account.
+var errNoSpace = fuse.Errno(syscall.ENOSPC)
+
+// pending returns how much space the handle will take on Put.io once it is
+// flushed.
+func (h *fileHandle) pending() int64 {
+	if !h.dirty {
+		return 0
+	}
+
+	n := h.size
+	// the remote file is deleted before the upload unless it is kept in the
+	// trash or as a previous version.
+	if h.f.fs.trash == nil && h.f.fs.versions == nil {
+		n -= h.remoteSize
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// pendingLocked returns the space required by all pending uploads. The caller
+// must hold f.stagingMu.
+func (f *FileSystem) pendingLocked() int64 {
+	var n int64
+	for h := range f.staging {
+		n += h.pending()
+	}
+	return n
+}
+
+// reserve grows the staged size of the handle to size. It fails with ENOSPC
+// if the pending uploads would not fit in the available space of the account
+// or the staging budget is exhausted.
+func (f *FileSystem) reserve(ctx context.Context, h *fileHandle, size int64) error {
+	avail := f.accountInfo(ctx).Disk.Avail
+
+	f.stagingMu.Lock()
+	before := f.pendingLocked()
+	oldSize, oldDirty := h.size, h.dirty
+	h.size, h.dirty = size, true
+	if after := f.pendingLocked(); after > before && after > avail {
+		h.size, h.dirty = oldSize, oldDirty
+		f.stagingMu.Unlock()
+		f.logger.Printf("no space left for %v: %v bytes pending, %v bytes available", h.f, after, avail)
+		return errNoSpace
+	}
+	f.stagingMu.Unlock()
+
+	if err := f.reserveLocal(ctx, h, size); err != nil {
+		f.stagingMu.Lock()
+		h.size, h.dirty = oldSize, oldDirty
+		f.stagingMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// hasRoom fails with ENOSPC if the pending uploads already use up the
+// available space of the account.
+func (f *FileSystem) hasRoom(ctx context.Context) error {
+	avail := f.accountInfo(ctx).Disk.Avail
+
+	f.stagingMu.Lock()
+	defer f.stagingMu.Unlock()
+
+	if f.pendingLocked() >= avail {
+		return errNoSpace
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+func TestPending(t *testing.T) {
+	tests := []struct {
+		dirty      bool
+		size       int64
+		remoteSize int64
+		keep       bool
+		want       int64
+	}{
+		{false, 100, 0, false, 0},
+		{true, 100, 0, false, 100},
+		{true, 100, 40, false, 60},
+		{true, 40, 100, false, 0},
+		{true, 100, 40, true, 100},
+		{true, 0, 0, false, 0},
+	}
+
+	for _, tt := range tests {
+		fsys := &FileSystem{}
+		if tt.keep {
+			fsys.trash = &trash{}
+		}
+		h := &fileHandle{
+			f:          &File{fs: fsys},
+			dirty:      tt.dirty,
+			size:       tt.size,
+			remoteSize: tt.remoteSize,
+		}
+		if got := h.pending(); got != tt.want {
+			t.Errorf("pending(dirty: %v, size: %v, remote: %v, keep: %v) = %v, want %v",
+				tt.dirty, tt.size, tt.remoteSize, tt.keep, got, tt.want)
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	tests := []struct {
+		avail   int64
+		other   int64
+		size    int64
+		wantErr bool
+	}{
+		{100, 0, 50, false},
+		{100, 0, 100, false},
+		{100, 0, 101, true},
+		{100, 60, 40, false},
+		{100, 60, 41, true},
+		// the pending uploads already exceed the quota, shrinking is
+		// still allowed.
+		{100, 150, 0, false},
+	}
+
+	for _, tt := range tests {
+		fsys := newQuotaFileSystem(tt.avail)
+		other := &fileHandle{f: &File{fs: fsys}, dirty: true, size: tt.other}
+		h := &fileHandle{f: &File{fs: fsys, File: &putio.File{Name: "file"}}}
+		fsys.staging[other] = struct{}{}
+		fsys.staging[h] = struct{}{}
+
+		err := fsys.reserve(context.Background(), h, tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("reserve(%v) with %v pending and %v available: error = %v, want error %v",
+				tt.size, tt.other, tt.avail, err, tt.wantErr)
+			continue
+		}
+		if err != nil && (h.dirty || h.size != 0) {
+			t.Errorf("reserve(%v) failed but left dirty: %v, size: %v", tt.size, h.dirty, h.size)
+		}
+		if err == nil && (!h.dirty || h.size != tt.size) {
+			t.Errorf("reserve(%v) = dirty: %v, size: %v", tt.size, h.dirty, h.size)
+		}
+	}
+}
+
+// newQuotaFileSystem returns a filesystem whose account has avail bytes
+// available, without asking Put.io.
+func newQuotaFileSystem(avail int64) *FileSystem {
+	fsys := &FileSystem{
+		logger:  NewLogger("putiofs: ", false),
+		staging: make(map[*fileHandle]struct{}),
+	}
+	fsys.account.Disk.Avail = avail
+	fsys.accountAt = time.Now()
+	return fsys
+}
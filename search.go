@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+const (
+	// maxSearchPages is the number of search result pages fetched for a
+	// query. Put.io returns 50 results per page.
+	maxSearchPages = 20
+
+	// searchCacheDuration is how long the results of a query are reused.
+	searchCacheDuration = time.Minute
+)
+
+// search returns the files matching query, following the pages of the
+// results.
+func (f *FileSystem) search(ctx context.Context, query string) ([]putio.File, error) {
+	// the query is put in the path as is.
+	query = url.PathEscape(query)
+
+	var files []putio.File
+	for page := int64(1); page <= maxSearchPages; page++ {
+		r, err := f.putio.Files.Search(ctx, query, page)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, r.Files...)
+		if r.Next == "" {
+			break
+		}
+	}
+	return files, nil
+}
+
+// filePath returns the path of the file in the mount, relative to the root.
+// parents caches the paths of the folders seen so far.
+func (f *FileSystem) filePath(ctx context.Context, file putio.File, parents map[int64]string) (string, error) {
+	var names []string
+	for id := file.ParentID; id != 0; {
+		if p, ok := parents[id]; ok {
+			names = append(names, p)
+			break
+		}
+
+		folder, err := f.get(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, folder.Name)
+		id = folder.ParentID
+	}
+
+	// names are from the parent up to the root.
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	dir := path.Join(names...)
+	parents[file.ParentID] = dir
+	return path.Join(dir, entryName(&file)), nil
+}
+
+// searchDir is the .search directory in the root. Looking up a name in it
+// searches the files with that name as the query.
+type searchDir struct {
+	fs *FileSystem
+}
+
+var (
+	_ fs.Node                = (*searchDir)(nil)
+	_ fs.NodeRequestLookuper = (*searchDir)(nil)
+	_ fs.HandleReadDirAller  = (*searchDir)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (d *searchDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	d.fs.perms.dir(attr, true)
+	return nil
+}
+
+// Lookup implements fs.NodeRequestLookuper interface.
+func (d *searchDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	if isJunkFile(req.Name) || strings.TrimSpace(req.Name) == "" {
+		return nil, fuse.ENOENT
+	}
+
+	d.fs.logger.Debugf("searchDir.Lookup(%q)", req.Name)
+	return &searchResults{fs: d.fs, query: req.Name}, nil
+}
+
+// ReadDirAll implements fs.HandleReadDirAller interface. Queries are not
+// listed.
+func (d *searchDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	return nil, nil
+}
+
+// searchResults is the directory of the files matching a query. Each match is
+// a symbolic link to the file in the mount.
+type searchResults struct {
+	fs    *FileSystem
+	query string
+
+	mu        sync.Mutex
+	entries   map[string]searchMatch
+	fetchedAt time.Time
+}
+
+// searchMatch is a file matching a query and its path in the mount.
+type searchMatch struct {
+	file putio.File
+	path string
+}
+
+// isHiddenPath reports whether the file at the given path in the mount is
+// used internally by putiofs.
+func isHiddenPath(p string, file *putio.File) bool {
+	if isLockFile(file) {
+		return true
+	}
+	top := strings.SplitN(p, "/", 2)[0]
+	return top == trashFolderName || top == versionsFolderName
+}
+
+var (
+	_ fs.Node                = (*searchResults)(nil)
+	_ fs.NodeRequestLookuper = (*searchResults)(nil)
+	_ fs.HandleReadDirAller  = (*searchResults)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (d *searchResults) Attr(ctx context.Context, attr *fuse.Attr) error {
+	d.fs.perms.dir(attr, true)
+	return nil
+}
+
+// results returns the matching files by entry name. Files with the same name
+// are prefixed with their IDs. The files used internally by putiofs are left
+// out.
+func (d *searchResults) results(ctx context.Context) (map[string]searchMatch, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.entries != nil && time.Since(d.fetchedAt) < searchCacheDuration {
+		return d.entries, nil
+	}
+
+	files, err := d.fs.search(ctx, d.query)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []searchMatch
+	parents := make(map[int64]string)
+	count := make(map[string]int)
+	for _, file := range files {
+		p, err := d.fs.filePath(ctx, file, parents)
+		if err != nil {
+			return nil, fmt.Errorf("could not find the path of %v: %v", file.ID, err)
+		}
+		if isHiddenPath(p, &file) {
+			continue
+		}
+		matches = append(matches, searchMatch{file: file, path: p})
+		count[entryName(&file)]++
+	}
+
+	entries := make(map[string]searchMatch)
+	for _, match := range matches {
+		name := entryName(&match.file)
+		if count[name] > 1 {
+			name = fmt.Sprintf("%v-%v", match.file.ID, name)
+		}
+		entries[name] = match
+	}
+
+	d.entries = entries
+	d.fetchedAt = time.Now()
+	return entries, nil
+}
+
+// Lookup implements fs.NodeRequestLookuper interface.
+func (d *searchResults) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	d.fs.logger.Debugf("searchResults.Lookup(%q) in %q", req.Name, d.query)
+
+	entries, err := d.results(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not search %q: %v", d.query, err)
+		return nil, fuse.EIO
+	}
+
+	match, ok := entries[req.Name]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	return &searchLink{fs: d.fs, File: &match.file, path: match.path}, nil
+}
+
+// ReadDirAll implements fs.HandleReadDirAller interface.
+func (d *searchResults) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.logger.Debugf("searchResults.ReadDirAll(%q)", d.query)
+
+	entries, err := d.results(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not search %q: %v", d.query, err)
+		return nil, fuse.EIO
+	}
+
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var dirents []fuse.Dirent
+	for _, name := range names {
+		dirents = append(dirents, fuse.Dirent{
+			Name: name,
+			Type: fuse.DT_Link,
+		})
+	}
+	return dirents, nil
+}
+
+// searchLink is a search match. It links to the file in the mount, relative
+// to .search/<query>/.
+type searchLink struct {
+	fs   *FileSystem
+	path string
+
+	*putio.File
+}
+
+var (
+	_ fs.Node           = (*searchLink)(nil)
+	_ fs.NodeReadlinker = (*searchLink)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (l *searchLink) Attr(ctx context.Context, attr *fuse.Attr) error {
+	attr.Mode = os.ModeSymlink | 0777
+	attr.Uid = l.fs.perms.uid
+	attr.Gid = l.fs.perms.gid
+	if l.CreatedAt != nil {
+		attr.Ctime = l.CreatedAt.Time
+		attr.Mtime = l.CreatedAt.Time
+		attr.Crtime = l.CreatedAt.Time
+	}
+	return nil
+}
+
+// Readlink implements fs.NodeReadlinker interface.
+func (l *searchLink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return path.Join("..", "..", l.path), nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// errInvalidSettings is returned when the written settings are rejected.
+var errInvalidSettings = fuse.Errno(syscall.EINVAL)
+
+// settingsFile returns the .settings file. It reads as the account settings in
+// JSON. Writing modified settings back applies the changes.
+func (f *FileSystem) settingsFile() *controlFile {
+	return &controlFile{
+		fs: f,
+		read: func(ctx context.Context) ([]byte, error) {
+			settings, err := f.putio.Account.Settings(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("could not fetch settings: %v", err)
+			}
+			b, err := json.MarshalIndent(settings, "", "  ")
+			return append(b, '\n'), err
+		},
+		write: func(ctx context.Context, data []byte) error {
+			current, err := f.putio.Account.Settings(ctx)
+			if err != nil {
+				return fmt.Errorf("could not fetch settings: %v", err)
+			}
+			settings, err := mergeSettings(current, data)
+			if err != nil {
+				f.logger.Printf("could not parse settings: %v", err)
+				return errInvalidSettings
+			}
+			return f.updateSettings(ctx, current, settings)
+		},
+	}
+}
+
+// mergeSettings returns the current settings with the fields present in the
+// JSON data replaced. The fields left out keep their current values.
+func mergeSettings(current putio.Settings, data []byte) (putio.Settings, error) {
+	settings := current
+	// the decoder would reuse the array of the current languages.
+	settings.SubtitleLanguages = append([]string(nil), current.SubtitleLanguages...)
+	err := json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+// updateSettings applies the fields of settings that differ from the current
+// settings. Changes to the fields that can not be set fail with EINVAL.
+func (f *FileSystem) updateSettings(ctx context.Context, current, settings putio.Settings) error {
+	if settings.DownloadFolderUnset != current.DownloadFolderUnset ||
+		!reflect.DeepEqual(settings.PrivateDownloadHostIP, current.PrivateDownloadHostIP) {
+		f.logger.Printf("download_folder_unset and private_download_host_ip can not be changed")
+		return errInvalidSettings
+	}
+
+	params := url.Values{}
+	setString := func(key, value, old string) {
+		if value != old {
+			params.Set(key, value)
+		}
+	}
+	setBool := func(key string, value, old bool) {
+		if value != old {
+			params.Set(key, strconv.FormatBool(value))
+		}
+	}
+
+	if settings.DefaultDownloadFolder != current.DefaultDownloadFolder {
+		folder, err := f.get(ctx, settings.DefaultDownloadFolder)
+		if err != nil || !folder.IsDir() {
+			f.logger.Printf("default download folder %v is not a folder", settings.DefaultDownloadFolder)
+			return errInvalidSettings
+		}
+		params.Set("default_download_folder", strconv.FormatInt(settings.DefaultDownloadFolder, 10))
+	}
+	for _, lang := range settings.SubtitleLanguages {
+		if !isLanguageCode(lang) {
+			f.logger.Printf("invalid subtitle language %q, expected a 3-letter ISO 639-2 code", lang)
+			return errInvalidSettings
+		}
+	}
+	if settings.DefaultSubtitleLanguage != "" && !isLanguageCode(settings.DefaultSubtitleLanguage) {
+		f.logger.Printf("invalid subtitle language %q, expected a 3-letter ISO 639-2 code", settings.DefaultSubtitleLanguage)
+		return errInvalidSettings
+	}
+	if !reflect.DeepEqual(settings.SubtitleLanguages, current.SubtitleLanguages) {
+		params.Set("subtitle_languages", strings.Join(settings.SubtitleLanguages, ","))
+	}
+
+	setString("default_subtitle_language", settings.DefaultSubtitleLanguage, current.DefaultSubtitleLanguage)
+	setString("routing", settings.Routing, current.Routing)
+	setString("sorting", settings.Sorting, current.Sorting)
+	setString("callback_url", settings.CallbackURL, current.CallbackURL)
+	setString("pushover_token", settings.PushoverToken, current.PushoverToken)
+	setBool("is_invisible", settings.IsInvisible, current.IsInvisible)
+	setBool("nextepisode", settings.Nextepisode, current.Nextepisode)
+	setBool("ssl_enabled", settings.SSLEnabled, current.SSLEnabled)
+	setBool("start_from", settings.StartFrom, current.StartFrom)
+
+	if len(params) == 0 {
+		return nil
+	}
+
+	f.logger.Debugf("updating settings: %v", params.Encode())
+	req, err := f.putio.NewRequest(ctx, "POST", "/v2/account/settings", strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if _, err := f.putio.Do(req, &struct{}{}); err != nil {
+		return fmt.Errorf("could not update settings: %v", err)
+	}
+
+	// subtitle languages are part of the account information.
+	f.invalidateAccount()
+	return nil
+}
+
+// isLanguageCode reports whether s looks like an ISO 639-2 language code.
+func isLanguageCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
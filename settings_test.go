@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/putdotio/go-putio/putio"
+)
+
+func TestIsLanguageCode(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"eng", true},
+		{"tur", true},
+		{"ENG", false},
+		{"en", false},
+		{"engl", false},
+		{"", false},
+		{"e1g", false},
+		{"en-", false},
+		{"çok", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLanguageCode(tt.s); got != tt.want {
+			t.Errorf("isLanguageCode(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMergeSettings(t *testing.T) {
+	current := putio.Settings{
+		DefaultDownloadFolder: 42,
+		Nextepisode:           true,
+		PrivateDownloadHostIP: "10.0.0.1",
+		Routing:               "Istanbul",
+		SSLEnabled:            true,
+		SubtitleLanguages:     []string{"eng", "tur"},
+	}
+
+	tests := []struct {
+		data string
+		want func(s *putio.Settings)
+	}{
+		{`{}`, func(s *putio.Settings) {}},
+		{`{"routing": "Amsterdam"}`, func(s *putio.Settings) { s.Routing = "Amsterdam" }},
+		{`{"ssl_enabled": false}`, func(s *putio.Settings) { s.SSLEnabled = false }},
+		{`{"subtitle_languages": ["fre"]}`, func(s *putio.Settings) { s.SubtitleLanguages = []string{"fre"} }},
+		{`{"subtitle_languages": []}`, func(s *putio.Settings) { s.SubtitleLanguages = []string{} }},
+	}
+
+	for _, tt := range tests {
+		want := current
+		want.SubtitleLanguages = append([]string(nil), current.SubtitleLanguages...)
+		tt.want(&want)
+
+		got, err := mergeSettings(current, []byte(tt.data))
+		if err != nil {
+			t.Errorf("mergeSettings(%s) failed: %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("mergeSettings(%s) = %+v, want %+v", tt.data, got, want)
+		}
+	}
+
+	if want := []string{"eng", "tur"}; !reflect.DeepEqual(current.SubtitleLanguages, want) {
+		t.Errorf("mergeSettings changed the current languages to %v", current.SubtitleLanguages)
+	}
+	if _, err := mergeSettings(current, []byte(`{"routing": 1}`)); err == nil {
+		t.Errorf("mergeSettings of a mistyped field did not fail")
+	}
+}
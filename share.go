@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+const (
+	// shareSuffix is the suffix of the share control of a file. The
+	// control of "name" is "name.share" next to it.
+	shareSuffix = ".share"
+
+	// sharedWithXattr is the extended attribute listing the friends a file
+	// is shared with. Setting it shares the file, removing it unshares it.
+	sharedWithXattr = putioXattrPrefix + "shared_with"
+
+	// shareEveryone shares a file with all friends.
+	shareEveryone = "everyone"
+
+	// sharedRootFolderType is the folder type of the folder that holds the
+	// files shared with the user.
+	sharedRootFolderType = "SHARED_ROOT"
+)
+
+// The sharing endpoints are not exported by the putio package, they are
+// called directly.
+
+// fileShare is a share of a file with a friend.
+type fileShare struct {
+	ID       int64  `json:"share_id"`
+	UserName string `json:"user_name"`
+}
+
+// share shares the file with the given friends. "everyone" shares it with
+// all friends.
+func (f *FileSystem) share(ctx context.Context, id int64, friends []string) error {
+	params := url.Values{}
+	params.Set("file_ids", strconv.FormatInt(id, 10))
+	params.Set("friends", strings.Join(friends, ","))
+	return f.post(ctx, "/v2/files/share", params)
+}
+
+// unshare stops the given shares of the file, or all of them if there are
+// none.
+func (f *FileSystem) unshare(ctx context.Context, id int64, shares []fileShare) error {
+	param := shareEveryone
+	if len(shares) > 0 {
+		var ids []string
+		for _, s := range shares {
+			ids = append(ids, strconv.FormatInt(s.ID, 10))
+		}
+		param = strings.Join(ids, ",")
+	}
+
+	params := url.Values{}
+	params.Set("shares", param)
+	return f.post(ctx, "/v2/files/"+strconv.FormatInt(id, 10)+"/unshare", params)
+}
+
+// sharedWith returns the shares of the file.
+func (f *FileSystem) sharedWith(ctx context.Context, id int64) ([]fileShare, error) {
+	req, err := f.putio.NewRequest(ctx, "GET", "/v2/files/"+strconv.FormatInt(id, 10)+"/shared-with", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		Shared []fileShare `json:"shared-with"`
+	}
+	if _, err := f.putio.Do(req, &r); err != nil {
+		return nil, err
+	}
+	return r.Shared, nil
+}
+
+// sharedRoot returns the folder that holds the files shared with the user.
+func (f *FileSystem) sharedRoot(ctx context.Context) (putio.File, bool, error) {
+	req, err := f.putio.NewRequest(ctx, "GET", "/v2/files/list?parent_id=0", nil)
+	if err != nil {
+		return putio.File{}, false, err
+	}
+
+	// the folder type is not in putio.File.
+	var r struct {
+		Files []struct {
+			putio.File
+			FolderType string `json:"folder_type"`
+		}
+	}
+	if _, err := f.putio.Do(req, &r); err != nil {
+		return putio.File{}, false, err
+	}
+
+	for _, file := range r.Files {
+		if file.FolderType == sharedRootFolderType {
+			return file.File, true, nil
+		}
+	}
+	return putio.File{}, false, nil
+}
+
+// post sends a form to the Put.io API.
+func (f *FileSystem) post(ctx context.Context, path string, params url.Values) error {
+	req, err := f.putio.NewRequest(ctx, "POST", path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err = f.putio.Do(req, &struct{}{})
+	return err
+}
+
+// shareFile returns the share control of a file. It reads as the friends the
+// file is shared with, one per line. Writing friend names into it, or
+// "everyone", shares the file with them only.
+func (f *FileSystem) shareFile(file putio.File) *controlFile {
+	return &controlFile{
+		fs: f,
+		read: func(ctx context.Context) ([]byte, error) {
+			shares, err := f.sharedWith(ctx, file.ID)
+			if err != nil {
+				return nil, fmt.Errorf("could not list the shares of %v: %v", file.ID, err)
+			}
+
+			var buf bytes.Buffer
+			for _, s := range shares {
+				buf.WriteString(s.UserName + "\n")
+			}
+			return buf.Bytes(), nil
+		},
+		write: func(ctx context.Context, data []byte) error {
+			names, err := parseNames(data)
+			if err != nil {
+				f.logger.Printf("could not read the friends to share %v with: %v", file.ID, err)
+				return fuse.Errno(syscall.EINVAL)
+			}
+			return f.shareWith(ctx, file.ID, names)
+		},
+	}
+}
+
+// shareWith makes the given friends the ones the file is shared with: it is
+// unshared from the others. "everyone" among them shares it with all
+// friends.
+func (f *FileSystem) shareWith(ctx context.Context, id int64, friends []string) error {
+	if len(friends) == 0 {
+		return fuse.Errno(syscall.EINVAL)
+	}
+	for _, name := range friends {
+		if name == shareEveryone {
+			friends = []string{shareEveryone}
+			break
+		}
+	}
+
+	shares, err := f.sharedWith(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not list the shares of %v: %v", id, err)
+	}
+
+	want := make(map[string]bool)
+	for _, name := range friends {
+		want[name] = true
+	}
+	shared := make(map[string]bool)
+	var stale []fileShare
+	for _, s := range shares {
+		shared[s.UserName] = true
+		if !want[s.UserName] && !want[shareEveryone] {
+			stale = append(stale, s)
+		}
+	}
+	var added []string
+	for _, name := range friends {
+		if !shared[name] {
+			added = append(added, name)
+		}
+	}
+
+	if len(stale) > 0 {
+		f.logger.Debugf("unsharing %v from %v", id, stale)
+		if err := f.unshare(ctx, id, stale); err != nil {
+			return fmt.Errorf("could not unshare %v: %v", id, err)
+		}
+	}
+	if len(added) > 0 {
+		f.logger.Debugf("sharing %v with %v", id, added)
+		if err := f.share(ctx, id, added); err != nil {
+			return fmt.Errorf("could not share %v: %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"bazil.org/fuse"
+	"golang.org/x/net/context"
+)
+
+// fillChunkSize is the size of the ranges downloaded to fill a staging file.
+const fillChunkSize = 4 << 20
+
+// extent is a byte range [off, end) of a file.
+type extent struct {
+	off, end int64
+}
+
+// extents is a sorted list of non-overlapping extents.
+type extents []extent
+
+// add marks the given range as covered, merging overlapping and adjacent
+// extents.
+func (e extents) add(off, end int64) extents {
+	if off >= end {
+		return e
+	}
+
+	var out extents
+	i := 0
+	for ; i < len(e) && e[i].end < off; i++ {
+		out = append(out, e[i])
+	}
+	for ; i < len(e) && e[i].off <= end; i++ {
+		if e[i].off < off {
+			off = e[i].off
+		}
+		if e[i].end > end {
+			end = e[i].end
+		}
+	}
+	out = append(out, extent{off, end})
+	return append(out, e[i:]...)
+}
+
+// clip drops everything at and after size.
+func (e extents) clip(size int64) extents {
+	var out extents
+	for _, x := range e {
+		if x.off >= size {
+			break
+		}
+		if x.end > size {
+			x.end = size
+		}
+		out = append(out, x)
+	}
+	return out
+}
+
+// gaps returns the ranges in [off, end) that are not covered.
+func (e extents) gaps(off, end int64) extents {
+	var out extents
+	for _, x := range e {
+		if x.end <= off {
+			continue
+		}
+		if x.off >= end {
+			break
+		}
+		if x.off > off {
+			out = append(out, extent{off, x.off})
+		}
+		off = x.end
+	}
+	if off < end {
+		out = append(out, extent{off, end})
+	}
+	return out
+}
+
+// fill copies the parts of [off, end) that are backed by the remote file but
+// not written locally into the staging file. It lets partial writes and
+// appends keep the rest of the original content.
+func (h *fileHandle) fill(ctx context.Context, off, end int64) error {
+	// writes into the gaps wait for them to be filled.
+	h.stagedMu.Lock()
+	defer h.stagedMu.Unlock()
+
+	if end > h.base {
+		end = h.base
+	}
+
+	for _, gap := range h.staged.gaps(off, end) {
+		for pos := gap.off; pos < gap.end; pos += fillChunkSize {
+			size := minInt64(fillChunkSize, gap.end-pos)
+			if err := h.fillChunk(ctx, pos, size); err != nil {
+				return err
+			}
+		}
+		h.staged = h.staged.add(gap.off, gap.end)
+	}
+	return nil
+}
+
+func (h *fileHandle) fillChunk(ctx context.Context, off, size int64) error {
+	h.f.fs.logger.Debugf("fileHandle.fill(%q, %v bytes at %v)", h.f.Name, size, off)
+
+	body, err := h.f.fs.download(ctx, h.f.ID, off, int(size))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(body, buf); err != nil {
+		return err
+	}
+
+	_, err = h.tmp.WriteAt(buf, off)
+	return err
+}
+
+// stagingLocked returns the local disk space used by the staging files. The
+// caller must hold f.stagingMu.
+func (f *FileSystem) stagingLocked() int64 {
+	var n int64
+	for h := range f.staging {
+		n += h.local
+	}
+	return n
+}
+
+// reserveLocal grows the local footprint of the handle's staging file to size.
+// If the staging budget is exhausted, it either blocks until other handles
+// release their staging files or fails with ENOSPC.
+func (f *FileSystem) reserveLocal(ctx context.Context, h *fileHandle, size int64) error {
+	for {
+		f.stagingMu.Lock()
+		used := f.stagingLocked()
+		if size <= h.local || f.stagingLimit <= 0 || used-h.local+size <= f.stagingLimit {
+			if size < h.local {
+				f.notifyStagingFreedLocked()
+			}
+			h.local = size
+			f.stagingMu.Unlock()
+			return nil
+		}
+
+		// nothing else can free space if the handle is the only one
+		// using it.
+		if !f.stagingBlock || used == h.local {
+			f.stagingMu.Unlock()
+			f.logger.Printf("staging budget exhausted for %v: %v bytes used, limit is %v", h.f, used, f.stagingLimit)
+			return errNoSpace
+		}
+
+		freed := f.stagingFreed
+		f.stagingMu.Unlock()
+
+		f.logger.Debugf("waiting for staging space for %v", h.f)
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return fuse.EINTR
+		}
+	}
+}
+
+// notifyStagingFreedLocked wakes up the writers waiting for staging space.
+// The caller must hold f.stagingMu.
+func (f *FileSystem) notifyStagingFreedLocked() {
+	if f.stagingFreed != nil {
+		close(f.stagingFreed)
+	}
+	f.stagingFreed = make(chan struct{})
+}
+
+// printUploadsChart returns a table of the files that are staged locally,
+// waiting to be or being uploaded.
+func (f *FileSystem) printUploadsChart() string {
+	f.stagingMu.Lock()
+	defer f.stagingMu.Unlock()
+
+	var buf bytes.Buffer
+	const padding = 3
+
+	w := tabwriter.NewWriter(&buf, 0, 0, padding, ' ', 0)
+	fmt.Fprintf(w, "Name\tStaged\tStatus\t\n")
+	fmt.Fprintf(w, "----\t------\t------\t\n")
+	// map order is random, list the files by name.
+	var handles []*fileHandle
+	for h := range f.staging {
+		if h.writable && h.local > 0 {
+			handles = append(handles, h)
+		}
+	}
+	sort.Slice(handles, func(i, j int) bool {
+		if handles[i].f.Name != handles[j].f.Name {
+			return handles[i].f.Name < handles[j].f.Name
+		}
+		return handles[i].f.ParentID < handles[j].f.ParentID
+	})
+
+	for _, h := range handles {
+		var status string
+		switch {
+		case h.uploading:
+			status = "uploading"
+		case h.dirty:
+			status = "pending"
+		default:
+			status = "uploaded"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t\n", h.f.Name, humanizeBytes(uint64(h.local)), status)
+	}
+	_ = w.Flush()
+
+	limit := "unlimited"
+	if f.stagingLimit > 0 {
+		limit = humanizeBytes(uint64(f.stagingLimit))
+	}
+	fmt.Fprintf(&buf, "\nStaging: %v of %v used in %v\n", humanizeBytes(uint64(f.stagingLocked())), limit, f.stagingDirName())
+	return buf.String()
+}
+
+// stagingDirName returns the directory the staging files are created in.
+func (f *FileSystem) stagingDirName() string {
+	if f.stagingDir == "" {
+		return os.TempDir()
+	}
+	return f.stagingDir
+}
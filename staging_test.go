@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+func TestExtentsAdd(t *testing.T) {
+	tests := []struct {
+		e        extents
+		off, end int64
+		want     extents
+	}{
+		{nil, 0, 10, extents{{0, 10}}},
+		{nil, 5, 5, nil},
+		{extents{{0, 10}}, 20, 30, extents{{0, 10}, {20, 30}}},
+		{extents{{20, 30}}, 0, 10, extents{{0, 10}, {20, 30}}},
+		{extents{{0, 10}}, 10, 20, extents{{0, 20}}},
+		{extents{{10, 20}}, 0, 10, extents{{0, 20}}},
+		{extents{{0, 10}}, 5, 15, extents{{0, 15}}},
+		{extents{{0, 10}, {20, 30}}, 5, 25, extents{{0, 30}}},
+		{extents{{0, 10}, {20, 30}, {40, 50}}, 15, 35, extents{{0, 10}, {15, 35}, {40, 50}}},
+		{extents{{0, 30}}, 10, 20, extents{{0, 30}}},
+	}
+
+	for _, tt := range tests {
+		got := tt.e.add(tt.off, tt.end)
+		if !equalExtents(got, tt.want) {
+			t.Errorf("%v.add(%v, %v) = %v, want %v", tt.e, tt.off, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestExtentsClip(t *testing.T) {
+	tests := []struct {
+		e    extents
+		size int64
+		want extents
+	}{
+		{nil, 10, nil},
+		{extents{{0, 10}}, 20, extents{{0, 10}}},
+		{extents{{0, 10}}, 10, extents{{0, 10}}},
+		{extents{{0, 10}}, 5, extents{{0, 5}}},
+		{extents{{0, 10}, {20, 30}}, 25, extents{{0, 10}, {20, 25}}},
+		{extents{{0, 10}, {20, 30}}, 20, extents{{0, 10}}},
+		{extents{{0, 10}}, 0, nil},
+	}
+
+	for _, tt := range tests {
+		got := tt.e.clip(tt.size)
+		if !equalExtents(got, tt.want) {
+			t.Errorf("%v.clip(%v) = %v, want %v", tt.e, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestExtentsGaps(t *testing.T) {
+	tests := []struct {
+		e        extents
+		off, end int64
+		want     extents
+	}{
+		{nil, 0, 10, extents{{0, 10}}},
+		{nil, 10, 10, nil},
+		{extents{{0, 10}}, 0, 10, nil},
+		{extents{{0, 10}}, 0, 20, extents{{10, 20}}},
+		{extents{{10, 20}}, 0, 30, extents{{0, 10}, {20, 30}}},
+		{extents{{0, 10}, {20, 30}}, 5, 25, extents{{10, 20}}},
+		{extents{{0, 10}, {20, 30}}, 30, 40, extents{{30, 40}}},
+		{extents{{20, 30}}, 0, 10, extents{{0, 10}}},
+	}
+
+	for _, tt := range tests {
+		got := tt.e.gaps(tt.off, tt.end)
+		if !equalExtents(got, tt.want) {
+			t.Errorf("%v.gaps(%v, %v) = %v, want %v", tt.e, tt.off, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestReserveLocal(t *testing.T) {
+	tests := []struct {
+		limit   int64
+		other   int64
+		local   int64
+		size    int64
+		wantErr bool
+	}{
+		{0, 1000, 0, 1000, false},
+		{100, 60, 0, 40, false},
+		{100, 60, 0, 41, true},
+		{100, 60, 30, 40, false},
+		{100, 60, 30, 41, true},
+		// shrinking always fits.
+		{100, 150, 30, 10, false},
+		{100, 0, 0, 101, true},
+	}
+
+	for _, tt := range tests {
+		fsys := &FileSystem{
+			logger:       NewLogger("putiofs: ", false),
+			staging:      make(map[*fileHandle]struct{}),
+			stagingLimit: tt.limit,
+			stagingFreed: make(chan struct{}),
+		}
+		other := &fileHandle{local: tt.other}
+		h := &fileHandle{f: &File{fs: fsys, File: &putio.File{Name: "file"}}, local: tt.local}
+		fsys.staging[other] = struct{}{}
+		fsys.staging[h] = struct{}{}
+
+		err := fsys.reserveLocal(context.Background(), h, tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("reserveLocal(%v) with limit %v, %v used by others and %v by the handle: error = %v, want error %v",
+				tt.size, tt.limit, tt.other, tt.local, err, tt.wantErr)
+			continue
+		}
+		want := tt.size
+		if err != nil {
+			want = tt.local
+		}
+		if h.local != want {
+			t.Errorf("reserveLocal(%v) left the handle at %v, want %v", tt.size, h.local, want)
+		}
+	}
+}
+
+// equalExtents reports whether a and b are the same, nil being the same as
+// empty.
+func equalExtents(a, b extents) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// Subtitles of videos are presented as "<video>.<lang>.<format>" files next
+// to them, for every subtitle language of the account that the video has a
+// subtitle in and every enabled format, so that media players load them
+// automatically. Languages are matched by their ISO 639-2 codes, as in the
+// account settings. The subtitles are downloaded when the files are opened.
+
+// subtitlesCacheDuration is how long the subtitle list of a video is reused.
+const subtitlesCacheDuration = 10 * time.Minute
+
+// subtitleFormats are the subtitle formats Put.io can convert to.
+var subtitleFormats = []string{"srt", "vtt"}
+
+// isSubtitleFormat reports whether Put.io can convert subtitles to format.
+func isSubtitleFormat(format string) bool {
+	for _, f := range subtitleFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// isVideo reports whether the file is a video.
+func isVideo(file *putio.File) bool {
+	return !file.IsDir() && strings.HasPrefix(file.ContentType, "video/")
+}
+
+// subtitleName returns the name of the subtitle file of the video.
+func subtitleName(video *putio.File, lang, format string) string {
+	name := entryName(video)
+	return strings.TrimSuffix(name, path.Ext(name)) + "." + lang + "." + format
+}
+
+// subtitleNames returns the names of the subtitle files of the videos among
+// files. Names taken by the files are left out.
+func (f *FileSystem) subtitleNames(ctx context.Context, files []putio.File) []string {
+	if len(f.subtitleFormats) == 0 {
+		return nil
+	}
+
+	taken := make(map[string]bool)
+	for _, file := range files {
+		taken[entryName(&file)] = true
+	}
+
+	var names []string
+	for _, file := range files {
+		if !isVideo(&file) {
+			continue
+		}
+		subs, err := f.videoSubtitles(ctx, file)
+		if err != nil {
+			f.logger.Printf("%v", err)
+			continue
+		}
+		for _, sub := range subs {
+			if name := subtitleName(&file, sub.lang, sub.format); !taken[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// lookupSubtitle returns the subtitle file with the given name, if it is the
+// subtitle of one of the videos among files.
+func (f *FileSystem) lookupSubtitle(ctx context.Context, files []putio.File, name string) (*subtitleFile, bool) {
+	if len(f.subtitleFormats) == 0 {
+		return nil, false
+	}
+
+	langs := f.accountInfo(ctx).SubtitleLanguages
+	for _, file := range files {
+		if !isVideo(&file) {
+			continue
+		}
+		for _, lang := range langs {
+			for _, format := range f.subtitleFormats {
+				if subtitleName(&file, lang, format) != name {
+					continue
+				}
+
+				subs, err := f.videoSubtitles(ctx, file)
+				if err != nil {
+					f.logger.Printf("%v", err)
+					return nil, false
+				}
+				for _, sub := range subs {
+					if sub.lang == lang && sub.format == format {
+						return sub, true
+					}
+				}
+				return nil, false
+			}
+		}
+	}
+	return nil, false
+}
+
+// videoSubtitles returns the subtitle files of the video, one per subtitle
+// language of the account that the video has a subtitle in and enabled
+// format. The first language is the preferred one: if the video has no
+// subtitle in it, the one Put.io picks by default is used.
+func (f *FileSystem) videoSubtitles(ctx context.Context, video putio.File) ([]*subtitleFile, error) {
+	subtitles, err := f.subtitleCache.list(ctx, f, video.ID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the subtitles of %v: %v", video.ID, err)
+	}
+
+	var subs []*subtitleFile
+	for i, lang := range f.accountInfo(ctx).SubtitleLanguages {
+		var key string
+		for _, subtitle := range subtitles {
+			if strings.EqualFold(subtitle.LanguageCode, lang) {
+				key = subtitle.Key
+				break
+			}
+		}
+		if key == "" {
+			if i > 0 || len(subtitles) == 0 {
+				continue
+			}
+			key = "default"
+		}
+
+		for _, format := range f.subtitleFormats {
+			subs = append(subs, &subtitleFile{
+				fs:     f,
+				video:  video,
+				lang:   lang,
+				format: format,
+				key:    key,
+			})
+		}
+	}
+	return subs, nil
+}
+
+// subtitleFile is the subtitle with the given key of a video, in a language
+// and format.
+type subtitleFile struct {
+	fs     *FileSystem
+	video  putio.File
+	lang   string
+	format string
+	key    string
+}
+
+var (
+	_ fs.Node       = (*subtitleFile)(nil)
+	_ fs.NodeOpener = (*subtitleFile)(nil)
+)
+
+// Attr implements fs.Node interface. The size is only known once the
+// subtitle is downloaded, it is 0 until then.
+func (s *subtitleFile) Attr(ctx context.Context, attr *fuse.Attr) error {
+	s.fs.perms.file(attr, true)
+	if s.video.CreatedAt != nil {
+		attr.Ctime = s.video.CreatedAt.Time
+		attr.Mtime = s.video.CreatedAt.Time
+		attr.Crtime = s.video.CreatedAt.Time
+	}
+	if size, ok := s.fs.subtitleCache.size(s.id()); ok {
+		attr.Size = uint64(size)
+	}
+	return nil
+}
+
+// Open implements fs.NodeOpener interface.
+func (s *subtitleFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.EPERM
+	}
+
+	content, err := s.fs.downloadSubtitle(ctx, s.video.ID, s.key, s.format)
+	if err != nil {
+		s.fs.logger.Printf("%v", err)
+		return nil, fuse.EIO
+	}
+	s.fs.subtitleCache.setSize(s.id(), int64(len(content)))
+
+	// the size may not have been known when the file was looked up.
+	resp.Flags |= fuse.OpenDirectIO
+	return fs.DataHandle(content), nil
+}
+
+// id identifies the subtitle among the subtitles of all videos.
+func (s *subtitleFile) id() string {
+	return fmt.Sprintf("%v/%v.%v", s.video.ID, s.key, s.format)
+}
+
+// subtitleCache keeps the subtitle lists of the videos, so that listing a
+// directory does not ask Put.io for them every time, and the sizes of the
+// subtitles downloaded so far.
+type subtitleCache struct {
+	mu    sync.Mutex
+	lists map[int64]subtitleList
+	sizes map[string]int64
+}
+
+type subtitleList struct {
+	subtitles []subtitle
+	fetchedAt time.Time
+}
+
+// list returns the subtitles of the video with the given ID.
+func (c *subtitleCache) list(ctx context.Context, f *FileSystem, id int64) ([]subtitle, error) {
+	c.mu.Lock()
+	l, ok := c.lists[id]
+	c.mu.Unlock()
+	if ok && time.Since(l.fetchedAt) < subtitlesCacheDuration {
+		return l.subtitles, nil
+	}
+
+	subtitles, err := f.subtitles(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lists == nil {
+		c.lists = make(map[int64]subtitleList)
+	}
+	// drop the lists that would be fetched again anyway.
+	for video, l := range c.lists {
+		if time.Since(l.fetchedAt) >= subtitlesCacheDuration {
+			delete(c.lists, video)
+		}
+	}
+	c.lists[id] = subtitleList{subtitles: subtitles, fetchedAt: time.Now()}
+	return subtitles, nil
+}
+
+func (c *subtitleCache) size(id string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size, ok := c.sizes[id]
+	return size, ok
+}
+
+func (c *subtitleCache) setSize(id string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sizes == nil {
+		c.sizes = make(map[string]int64)
+	}
+	c.sizes[id] = size
+}
+
+// subtitle is a subtitle of a video. putio.Subtitle lacks the language code,
+// Language is the display name of the language.
+type subtitle struct {
+	Key          string `json:"key"`
+	Language     string `json:"language"`
+	LanguageCode string `json:"language_code"`
+}
+
+// subtitles returns the subtitles of the video with the given ID.
+func (f *FileSystem) subtitles(ctx context.Context, id int64) ([]subtitle, error) {
+	req, err := f.putio.NewRequest(ctx, "GET", "/v2/files/"+strconv.FormatInt(id, 10)+"/subtitles", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		Subtitles []subtitle `json:"subtitles"`
+	}
+	if _, err := f.putio.Do(req, &r); err != nil {
+		return nil, err
+	}
+	return r.Subtitles, nil
+}
+
+// downloadSubtitle returns the subtitle with the given key in the given
+// format. putio.FilesService.DownloadSubtitle ignores the format, so the
+// request is made directly.
+func (f *FileSystem) downloadSubtitle(ctx context.Context, id int64, key, format string) ([]byte, error) {
+	u := "/v2/files/" + strconv.FormatInt(id, 10) + "/subtitles/" + key + "?format=" + format
+	req, err := f.putio.NewRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.putio.Do(req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not download subtitle %v of %v: %v", key, id, err)
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
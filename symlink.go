@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// symlinkSuffix marks the files on Put.io that store a symbolic link. The
+// content of such a file is the link target. putiofs presents them as
+// symbolic links named without the suffix.
+const symlinkSuffix = ".putiofs-symlink"
+
+// isSymlinkFile reports whether the file on Put.io stores a symbolic link.
+func isSymlinkFile(file *putio.File) bool {
+	return !file.IsDir() && strings.HasSuffix(file.Name, symlinkSuffix)
+}
+
+// entryName returns the name of the file as it is shown in the mount.
+func entryName(file *putio.File) string {
+	if isSymlinkFile(file) {
+		return strings.TrimSuffix(file.Name, symlinkSuffix)
+	}
+	return file.Name
+}
+
+// Symlink is a symbolic link stored as a file on Put.io.
+type Symlink struct {
+	fs *FileSystem
+
+	*putio.File // metadata
+
+	// target is the cached link target. Empty until read.
+	target string
+}
+
+var (
+	_ fs.Node           = (*Symlink)(nil)
+	_ fs.NodeReadlinker = (*Symlink)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (s *Symlink) Attr(ctx context.Context, attr *fuse.Attr) error {
+	s.fs.logger.Debugf("symlink.Attr(%q)", s.Name)
+
+	attr.Mode = os.ModeSymlink | 0777
+	attr.Uid = s.fs.perms.uid
+	attr.Gid = s.fs.perms.gid
+	attr.Size = uint64(s.Size)
+	if s.CreatedAt != nil {
+		attr.Ctime = s.CreatedAt.Time
+		attr.Mtime = s.CreatedAt.Time
+		attr.Crtime = s.CreatedAt.Time
+	}
+	return nil
+}
+
+// Readlink implements fs.NodeReadlinker interface. The target is the content
+// of the link file.
+func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	s.fs.logger.Debugf("symlink.Readlink(%q)", s.Name)
+
+	if s.target != "" || s.Size == 0 {
+		return s.target, nil
+	}
+
+	body, err := s.fs.download(ctx, s.ID, 0, int(s.Size))
+	if err != nil {
+		s.fs.logger.Printf("could not download symlink %q: %v", s.Name, err)
+		return "", fuse.EIO
+	}
+	defer body.Close()
+
+	target, err := ioutil.ReadAll(body)
+	if err != nil {
+		s.fs.logger.Printf("could not read symlink %q: %v", s.Name, err)
+		return "", fuse.EIO
+	}
+
+	s.target = string(target)
+	return s.target, nil
+}
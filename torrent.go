@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// Put.io turns uploaded .torrent files into transfers instead of storing them.
+// Such a file never exists on Put.io: it is written locally and starts the
+// transfer once it is flushed. If the torrent file is to be kept too, it is
+// uploaded again under a temporary name and renamed back, since renames do
+// not start transfers.
+
+// keptTorrentSuffix is appended to the name of a torrent file while it is
+// uploaded to be kept.
+const keptTorrentSuffix = ".putiofs-upload"
+
+// isTorrentName reports whether a file with the given name is a torrent file
+// for Put.io.
+func isTorrentName(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".torrent")
+}
+
+// torrentAdded records the transfer started by uploading the torrent file of
+// the handle. The torrent file is uploaded once more to keep it if
+// -keep-torrents is set, otherwise the file stays local only.
+func (h *fileHandle) torrentAdded(ctx context.Context, t *putio.Transfer) error {
+	fsys := h.f.fs
+	fsys.logger.Printf("%q started transfer %q", h.f.Name, t.Name)
+	fsys.transferResults.add(h.f.ParentID, fmt.Sprintf("✓ %v: %v", h.f.Name, t.Name))
+
+	if !fsys.keepTorrents {
+		return nil
+	}
+
+	if _, err := h.tmp.Seek(0, 0); err != nil {
+		return err
+	}
+	u, err := fsys.upload(ctx, h.tmp, h.f.Name+keptTorrentSuffix, h.f.ParentID)
+	if err != nil {
+		return fmt.Errorf("could not upload: %v", err)
+	}
+	if u.File == nil {
+		return fmt.Errorf("could not create new file on remote")
+	}
+	if err := fsys.rename(ctx, u.File.ID, h.f.Name); err != nil {
+		return fmt.Errorf("could not rename %v: %v", u.File.ID, err)
+	}
+
+	name := h.f.Name
+	*h.f.File = *u.File
+	h.f.Name = name
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// maxTransferResults is the number of transfer additions remembered per
+// directory to report back in .transfers.
+const maxTransferResults = 20
+
+// transferResults remembers the outcome of the transfers added through the
+// .transfers files, per directory.
+type transferResults struct {
+	mu      sync.Mutex
+	results map[int64][]string
+}
+
+func (r *transferResults) add(dir int64, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.results == nil {
+		r.results = make(map[int64][]string)
+	}
+	results := append(r.results[dir], result)
+	if len(results) > maxTransferResults {
+		results = results[len(results)-maxTransferResults:]
+	}
+	r.results[dir] = results
+}
+
+func (r *transferResults) get(dir int64) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.results[dir]...)
+}
+
+// addTransfer starts a transfer of the given URL or magnet link into dir and
+// records the outcome.
+func (f *FileSystem) addTransfer(ctx context.Context, dir int64, url string) (putio.Transfer, error) {
+	t, err := f.putio.Transfers.Add(ctx, url, dir, "")
+	if err != nil {
+		f.logger.Printf("could not add transfer %q: %v", url, err)
+		f.transferResults.add(dir, fmt.Sprintf("✗ %v: %v", url, err))
+		return t, err
+	}
+
+	f.logger.Debugf("added transfer %q into %v", t.Name, dir)
+	f.transferResults.add(dir, fmt.Sprintf("✓ %v: %v", url, t.Name))
+	f.invalidateAccount()
+	return t, nil
+}
+
+// transfersFile returns the .transfers file of a directory. Reading it shows
+// the transfers and the outcome of the last additions in the directory.
+// Writing URLs or magnet links into it, one per line, adds them as transfers
+// saved in the directory.
+func (f *FileSystem) transfersFile(dir int64) *controlFile {
+	return &controlFile{
+		fs: f,
+		read: func(ctx context.Context) ([]byte, error) {
+			ts, err := f.putio.Transfers.List(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("could not list transfers: %v", err)
+			}
+
+			var buf bytes.Buffer
+			buf.WriteString(printTransfersChart(ts))
+			if results := f.transferResults.get(dir); len(results) > 0 {
+				buf.WriteString("\nAdded here:\n")
+				for _, result := range results {
+					buf.WriteString(result + "\n")
+				}
+			}
+			return buf.Bytes(), nil
+		},
+		write: func(ctx context.Context, data []byte) error {
+			// every line is tried, it fails if any of them could not be
+			// added.
+			var failed bool
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			for scanner.Scan() {
+				url := strings.TrimSpace(scanner.Text())
+				if url == "" || strings.HasPrefix(url, "#") {
+					continue
+				}
+				if _, err := f.addTransfer(ctx, dir, url); err != nil {
+					failed = true
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				f.logger.Printf("could not read transfer links: %v", err)
+				return fuse.Errno(syscall.EINVAL)
+			}
+			if failed {
+				return fuse.EIO
+			}
+			return nil
+		},
+	}
+}
+
+// transferEntryName returns the name of the transfer in .transfers.d.
+func transferEntryName(t putio.Transfer) string {
+	return fmt.Sprintf("%v-%v", t.ID, strings.Replace(t.Name, "/", "_", -1))
+}
+
+// parseTransferEntryName returns the transfer ID of an entry in
+// .transfers.d.
+func parseTransferEntryName(name string) (int64, bool) {
+	i := strings.Index(name, "-")
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// transfersDir is the .transfers.d directory. Each transfer is a file that
+// reads as its JSON state. Writing "retry" into it retries the transfer and
+// removing it cancels the transfer. Writing into .clean removes the finished
+// transfers from the list.
+type transfersDir struct {
+	fs *FileSystem
+}
+
+var (
+	_ fs.Node                = (*transfersDir)(nil)
+	_ fs.NodeRequestLookuper = (*transfersDir)(nil)
+	_ fs.HandleReadDirAller  = (*transfersDir)(nil)
+	_ fs.NodeRemover         = (*transfersDir)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (d *transfersDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	d.fs.perms.dir(attr, false)
+	return nil
+}
+
+// Lookup implements fs.NodeRequestLookuper interface.
+func (d *transfersDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	d.fs.logger.Debugf("transfersDir.Lookup(%q)", req.Name)
+
+	if req.Name == ".clean" {
+		return &controlFile{
+			fs: d.fs,
+			write: func(ctx context.Context, data []byte) error {
+				return d.fs.putio.Transfers.Clean(ctx)
+			},
+		}, nil
+	}
+
+	id, ok := parseTransferEntryName(req.Name)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	t, err := d.fs.putio.Transfers.Get(ctx, id)
+	if err != nil || transferEntryName(t) != req.Name {
+		return nil, fuse.ENOENT
+	}
+
+	return &controlFile{
+		fs: d.fs,
+		read: func(ctx context.Context) ([]byte, error) {
+			t, err := d.fs.putio.Transfers.Get(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			b, err := json.MarshalIndent(t, "", "  ")
+			return append(b, '\n'), err
+		},
+		write: func(ctx context.Context, data []byte) error {
+			if cmd := strings.TrimSpace(string(data)); cmd != "retry" {
+				d.fs.logger.Printf("unknown transfer command %q", cmd)
+				return fuse.Errno(syscall.EINVAL)
+			}
+			_, err := d.fs.putio.Transfers.Retry(ctx, id)
+			return err
+		},
+	}, nil
+}
+
+// ReadDirAll implements fs.HandleReadDirAller interface.
+func (d *transfersDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.logger.Debugf("transfersDir.ReadDirAll()")
+
+	ts, err := d.fs.putio.Transfers.List(ctx)
+	if err != nil {
+		d.fs.logger.Printf("could not list transfers: %v", err)
+		return nil, fuse.EIO
+	}
+
+	entries := []fuse.Dirent{{Name: ".clean", Type: fuse.DT_File}}
+	for _, t := range ts {
+		entries = append(entries, fuse.Dirent{
+			Name: transferEntryName(t),
+			Type: fuse.DT_File,
+		})
+	}
+	return entries, nil
+}
+
+// Remove implements fs.NodeRemover interface. Removing a transfer cancels
+// it.
+func (d *transfersDir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	d.fs.logger.Debugf("transfersDir.Remove(%q)", req.Name)
+
+	id, ok := parseTransferEntryName(req.Name)
+	if !ok {
+		return fuse.EPERM
+	}
+
+	if err := d.fs.putio.Transfers.Cancel(ctx, id); err != nil {
+		d.fs.logger.Printf("could not cancel transfer %v: %v", id, err)
+		return fuse.EIO
+	}
+	return nil
+}
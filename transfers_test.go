@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/putdotio/go-putio/putio"
+)
+
+func TestParseTransferEntryName(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   int64
+		wantOK bool
+	}{
+		{"42-ubuntu.iso", 42, true},
+		{"42-", 42, true},
+		{"42-name-with-dashes", 42, true},
+		{"42", 0, false},
+		{"-42-name", 0, false},
+		{"abc-name", 0, false},
+		{".clean", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseTransferEntryName(tt.name)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseTransferEntryName(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestTransferEntryNameRoundTrip(t *testing.T) {
+	for _, tr := range []putio.Transfer{
+		{ID: 1, Name: "ubuntu.iso"},
+		{ID: 2, Name: "a/b"},
+		{ID: 3, Name: "-"},
+	} {
+		id, ok := parseTransferEntryName(transferEntryName(tr))
+		if !ok || id != tr.ID {
+			t.Errorf("parseTransferEntryName(transferEntryName(%v)) = %v, %v", tr.ID, id, ok)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+const (
+	// trashFolderName is the name of the hidden folder on Put.io that holds
+	// deleted files. It lives under the root folder and is exposed in the
+	// mount as ".trash".
+	trashFolderName = ".putiofs-trash"
+
+	// trashDayLayout is the layout of the per-day folders inside the trash
+	// folder. Retention is calculated from the folder name.
+	trashDayLayout = "2006-01-02"
+
+	// trashPurgeInterval is how often expired trash folders are purged.
+	trashPurgeInterval = time.Hour
+)
+
+// trash moves deleted files into a hidden folder on Put.io instead of
+// deleting them permanently. Each deletion goes into a folder named after the
+// day it is deleted, under the path of the folder it is deleted from, so a
+// file can be restored by moving it out of .trash/<day>/<path>/. Removing a
+// tree with "rm -r" keeps its structure in the trash.
+type trash struct {
+	fs *FileSystem
+
+	// retention is how long trashed files are kept. Zero means forever.
+	retention time.Duration
+
+	mu     sync.Mutex
+	folder *putio.File
+
+	// putMu serializes the deletions. folders caches the IDs of the
+	// folders in the trash by their path, including the day.
+	putMu   sync.Mutex
+	folders map[string]int64
+}
+
+func newTrash(fs *FileSystem, retention time.Duration) *trash {
+	return &trash{
+		fs:        fs,
+		retention: retention,
+		folders:   make(map[string]int64),
+	}
+}
+
+// root returns the trash folder, creating it if it does not exist yet.
+func (t *trash) root(ctx context.Context) (*putio.File, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.folder != nil {
+		return t.folder, nil
+	}
+
+	folder, err := t.fs.findOrCreateFolder(ctx, trashFolderName, 0)
+	if err != nil {
+		return nil, err
+	}
+	t.folder = &folder
+	return t.folder, nil
+}
+
+// put moves the given file into today's trash folder, under the path of its
+// parent.
+func (t *trash) put(ctx context.Context, file putio.File) error {
+	t.putMu.Lock()
+	defer t.putMu.Unlock()
+
+	root, err := t.root(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get trash folder: %v", err)
+	}
+
+	parents, err := t.fs.parentNames(ctx, file.ParentID)
+	if err != nil {
+		return fmt.Errorf("could not get the path of %v: %v", file.ID, err)
+	}
+
+	names := append([]string{time.Now().Format(trashDayLayout)}, parents...)
+	folder, err := t.dir(ctx, root.ID, names)
+	if err != nil {
+		return fmt.Errorf("could not get trash folder %q: %v", path.Join(names...), err)
+	}
+
+	// the children of a removed directory are trashed one by one before
+	// the directory itself, which is then empty. its copy in the trash
+	// already exists.
+	if file.IsDir() {
+		key := path.Join(append(names, file.Name)...)
+		if _, ok := t.folders[key]; ok {
+			children, err := t.fs.list(ctx, file.ID)
+			if err == nil && len(children) == 0 {
+				return t.fs.delete(ctx, file.ID)
+			}
+		}
+	}
+
+	t.fs.logger.Debugf("trash.put(%v) into %q", file.ID, path.Join(names...))
+	if err := t.fs.move(ctx, folder, file.ID); err != nil {
+		// the cached folders may have been removed meanwhile.
+		t.folders = make(map[string]int64)
+		return err
+	}
+	if file.IsDir() {
+		t.folders[path.Join(append(names, file.Name)...)] = file.ID
+	}
+	return nil
+}
+
+// dir returns the ID of the folder at the given path under the trash folder,
+// creating the missing folders. The caller must hold t.putMu.
+func (t *trash) dir(ctx context.Context, root int64, names []string) (int64, error) {
+	id := root
+	for i := range names {
+		key := path.Join(names[:i+1]...)
+		if cached, ok := t.folders[key]; ok {
+			id = cached
+			continue
+		}
+
+		folder, err := t.fs.findOrCreateFolder(ctx, names[i], id)
+		if err != nil {
+			return 0, err
+		}
+		t.folders[key] = folder.ID
+		id = folder.ID
+	}
+	return id, nil
+}
+
+// purge permanently deletes the trash folders older than the retention
+// period.
+func (t *trash) purge(ctx context.Context) error {
+	if t.retention <= 0 {
+		return nil
+	}
+
+	root, err := t.root(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get trash folder: %v", err)
+	}
+
+	files, err := t.fs.list(ctx, root.ID)
+	if err != nil {
+		return fmt.Errorf("could not list trash folder: %v", err)
+	}
+
+	deadline := time.Now().Add(-t.retention)
+	var expired []int64
+	for _, file := range files {
+		if trashDayExpired(file.Name, deadline) {
+			expired = append(expired, file.ID)
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	t.putMu.Lock()
+	t.folders = make(map[string]int64)
+	t.putMu.Unlock()
+
+	t.fs.logger.Debugf("trash.purge(): deleting %v expired folders", len(expired))
+	return t.fs.delete(ctx, expired...)
+}
+
+// trashDayExpired reports whether the trash folder with the given name is a
+// day folder whose last possible deletion is before deadline.
+func trashDayExpired(name string, deadline time.Time) bool {
+	day, err := time.ParseInLocation(trashDayLayout, name, time.Local)
+	if err != nil {
+		return false
+	}
+	return day.AddDate(0, 0, 1).Before(deadline)
+}
+
+// run purges the trash periodically. It never returns.
+func (t *trash) run() {
+	for {
+		if err := t.purge(context.Background()); err != nil {
+			t.fs.logger.Printf("could not purge trash: %v", err)
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
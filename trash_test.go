@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashDayExpired(t *testing.T) {
+	deadline := time.Date(2018, 3, 14, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"2018-03-12", true},
+		{"2018-03-13", false},
+		{"2018-03-14", false},
+		{"2018-03-15", false},
+		{"2017-12-31", true},
+		{"not-a-day", false},
+		{"2018-3-1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := trashDayExpired(tt.name, deadline); got != tt.want {
+			t.Errorf("trashDayExpired(%q, %v) = %v, want %v", tt.name, deadline, got, tt.want)
+		}
+	}
+}
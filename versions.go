@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+const (
+	// versionsFolderName is the name of the hidden folder on Put.io that
+	// holds previous versions of overwritten files. Versions are stored as
+	// <versionsFolderName>/<parent id>/<file name>/<timestamp>.
+	versionsFolderName = ".putiofs-versions"
+
+	// versionLayout is the layout of the version file names. It sorts
+	// lexically and avoids characters that are invalid on Samba shares.
+	versionLayout = "20060102-150405"
+)
+
+// versions keeps the previous versions of overwritten files in a hidden folder
+// on Put.io. They are exposed read-only as .versions/<name>/<timestamp> in the
+// directory of the file.
+type versions struct {
+	fs *FileSystem
+
+	// keep is the number of versions kept per file.
+	keep int
+
+	mu     sync.Mutex
+	folder *putio.File
+}
+
+func newVersions(fs *FileSystem, keep int) *versions {
+	return &versions{
+		fs:   fs,
+		keep: keep,
+	}
+}
+
+// root returns the versions folder, creating it if it does not exist yet.
+func (v *versions) root(ctx context.Context) (*putio.File, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.folder != nil {
+		return v.folder, nil
+	}
+
+	folder, err := v.fs.findOrCreateFolder(ctx, versionsFolderName, 0)
+	if err != nil {
+		return nil, err
+	}
+	v.folder = &folder
+	return v.folder, nil
+}
+
+// dir returns the folder that holds the versions of the files in the given
+// directory. ok is false if no file in the directory has any version yet.
+func (v *versions) dir(ctx context.Context, parent int64) (dir putio.File, ok bool, err error) {
+	root, err := v.root(ctx)
+	if err != nil {
+		return putio.File{}, false, err
+	}
+
+	files, err := v.fs.list(ctx, root.ID)
+	if err != nil {
+		return putio.File{}, false, err
+	}
+
+	name := strconv.FormatInt(parent, 10)
+	for _, file := range files {
+		if file.Name == name && file.IsDir() {
+			return file, true, nil
+		}
+	}
+	return putio.File{}, false, nil
+}
+
+// put moves the given file into the versions folder and deletes the oldest
+// versions of it exceeding the limit.
+func (v *versions) put(ctx context.Context, file *putio.File) error {
+	root, err := v.root(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get versions folder: %v", err)
+	}
+
+	parent, err := v.fs.findOrCreateFolder(ctx, strconv.FormatInt(file.ParentID, 10), root.ID)
+	if err != nil {
+		return fmt.Errorf("could not get versions folder of %v: %v", file.ParentID, err)
+	}
+
+	folder, err := v.fs.findOrCreateFolder(ctx, file.Name, parent.ID)
+	if err != nil {
+		return fmt.Errorf("could not get versions folder of %q: %v", file.Name, err)
+	}
+
+	// a version is named after the time it was created, not the time it
+	// was overwritten.
+	createdAt := time.Now()
+	if file.CreatedAt != nil {
+		createdAt = file.CreatedAt.Time
+	}
+	name := createdAt.UTC().Format(versionLayout)
+
+	v.fs.logger.Debugf("versions.put(%v) as %q", file, name)
+
+	if err := v.fs.move(ctx, folder.ID, file.ID); err != nil {
+		return err
+	}
+	if err := v.fs.rename(ctx, file.ID, name); err != nil {
+		// put the file back so that it is not lost among the versions. If
+		// that fails too, it is a version under its own name.
+		if merr := v.fs.move(ctx, file.ParentID, file.ID); merr != nil {
+			v.fs.logger.Printf("could not rename version %v, kept it as %q: %v", file.ID, file.Name, err)
+			return nil
+		}
+		return err
+	}
+
+	// the file is a version now, failing to prune must not fail the upload
+	// that replaces it.
+	if err := v.prune(ctx, folder.ID); err != nil {
+		v.fs.logger.Printf("could not prune the versions of %q: %v", file.Name, err)
+	}
+	return nil
+}
+
+// prune deletes all but the newest v.keep versions in the given folder.
+func (v *versions) prune(ctx context.Context, folder int64) error {
+	files, err := v.fs.list(ctx, folder)
+	if err != nil {
+		return err
+	}
+
+	if len(files) <= v.keep {
+		return nil
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name > files[j].Name
+	})
+
+	var expired []int64
+	for _, file := range files[v.keep:] {
+		expired = append(expired, file.ID)
+	}
+
+	v.fs.logger.Debugf("versions.prune(%v): deleting %v versions", folder, len(expired))
+	return v.fs.delete(ctx, expired...)
+}
+
+// readonlyDir is a read-only view of a directory on Put.io. Its files cannot
+// be written to and no entries can be created or removed.
+type readonlyDir struct {
+	fs *FileSystem
+
+	*putio.File
+}
+
+var (
+	_ fs.Node                = (*readonlyDir)(nil)
+	_ fs.NodeRequestLookuper = (*readonlyDir)(nil)
+	_ fs.HandleReadDirAller  = (*readonlyDir)(nil)
+)
+
+// Attr implements fs.Node interface.
+func (d *readonlyDir) Attr(ctx context.Context, attr *fuse.Attr) error {
+	d.fs.logger.Debugf("readonlyDir.Attr(%q)", d.Name)
+
+	d.fs.perms.dir(attr, true)
+	attr.Size = uint64(d.Size)
+	return nil
+}
+
+// Lookup implements fs.NodeRequestLookuper interface.
+func (d *readonlyDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fs.Node, error) {
+	if isJunkFile(req.Name) {
+		return nil, fuse.ENOENT
+	}
+
+	d.fs.logger.Debugf("readonlyDir.Lookup(%q) in %q", req.Name, d.Name)
+
+	files, err := d.fs.list(ctx, d.ID)
+	if err != nil {
+		d.fs.logger.Printf("could not lookup file %q: %v", req.Name, err)
+		return nil, fuse.EIO
+	}
+
+	for _, file := range files {
+		if file.Name != req.Name {
+			continue
+		}
+
+		if file.IsDir() {
+			return &readonlyDir{
+				fs:   d.fs,
+				File: &file,
+			}, nil
+		}
+		return &File{
+			fs:       d.fs,
+			File:     &file,
+			readonly: true,
+		}, nil
+	}
+
+	return nil, fuse.ENOENT
+}
+
+// ReadDirAll implements fs.HandleReadDirAller interface.
+func (d *readonlyDir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	d.fs.logger.Debugf("readonlyDir.ReadDirAll(%q)", d.Name)
+
+	files, err := d.fs.list(ctx, d.ID)
+	if err != nil {
+		d.fs.logger.Printf("could not list directory %q: %v", d.Name, err)
+		return nil, fuse.EIO
+	}
+
+	var entries []fuse.Dirent
+	for _, file := range files {
+		dt := fuse.DT_File
+		if file.IsDir() {
+			dt = fuse.DT_Dir
+		}
+		entries = append(entries, fuse.Dirent{
+			Name: file.Name,
+			Type: dt,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+// Setxattr flags, see setxattr(2).
+const (
+	xattrCreate  = 0x1
+	xattrReplace = 0x2
+)
+
+// putioXattrPrefix is the namespace of the read-only extended attributes
+// that expose Put.io file metadata.
+const putioXattrPrefix = "user.putio."
+
+// putioXattrs returns the Put.io metadata of the file as extended attributes.
+// Empty fields are left out.
+func putioXattrs(f *putio.File) map[string]string {
+	attrs := map[string]string{
+		"id":                 strconv.FormatInt(f.ID, 10),
+		"parent_id":          strconv.FormatInt(f.ParentID, 10),
+		"content_type":       f.ContentType,
+		"crc32":              f.CRC32,
+		"opensubtitles_hash": f.OpensubtitlesHash,
+		"is_mp4_available":   strconv.FormatBool(f.IsMP4Available),
+		"is_shared":          strconv.FormatBool(f.IsShared),
+		"screenshot":         f.Screenshot,
+	}
+	if f.FirstAccessedAt != nil {
+		attrs["first_accessed_at"] = f.FirstAccessedAt.Format(time.RFC3339)
+	}
+
+	xattrs := make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		if value != "" {
+			xattrs[putioXattrPrefix+name] = value
+		}
+	}
+	return xattrs
+}
+
+// getPutioXattr answers a Getxattr request for a user.putio.* attribute of
+// the file.
+func getPutioXattr(f *putio.File, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	value, ok := putioXattrs(f)[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = []byte(value)
+	return nil
+}
+
+// listPutioXattrs appends the names of the user.putio.* attributes of the file
+// to resp.
+func listPutioXattrs(f *putio.File, resp *fuse.ListxattrResponse) {
+	var names []string
+	for name := range putioXattrs(f) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+}
+
+// isPutioXattr reports whether the attribute is one of the read-only Put.io
+// attributes.
+func isPutioXattr(name string) bool {
+	return strings.HasPrefix(name, putioXattrPrefix)
+}
+
+// isUserXattr reports whether the attribute can be set by users. The
+// namespaces reserved for the kernel are not supported.
+func isUserXattr(name string) bool {
+	for _, prefix := range []string{"security.", "system.", "trusted."} {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return !isPutioXattr(name)
+}
+
+// getxattr answers a Getxattr request for the given file, from Put.io
+// metadata or the user defined attributes in the metadata overlay.
+func (f *FileSystem) getxattr(ctx context.Context, file *putio.File, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	// files kept locally are not on Put.io.
+	if file.ID < 0 {
+		return fuse.ErrNoXattr
+	}
+	if req.Name == sharedWithXattr {
+		shares, err := f.sharedWith(ctx, file.ID)
+		if err != nil {
+			f.logger.Printf("could not list the shares of %v: %v", file, err)
+			return fuse.EIO
+		}
+		if len(shares) == 0 {
+			return fuse.ErrNoXattr
+		}
+		var names []string
+		for _, s := range shares {
+			names = append(names, s.UserName)
+		}
+		resp.Xattr = []byte(strings.Join(names, ","))
+		return nil
+	}
+	if isPutioXattr(req.Name) {
+		return getPutioXattr(file, req, resp)
+	}
+
+	if f.meta == nil {
+		return fuse.ErrNoXattr
+	}
+	m, _ := f.meta.get(file.ID)
+	value, ok := m.Xattrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = value
+	return nil
+}
+
+// listxattr lists the Put.io and the user defined attributes of the given
+// file.
+func (f *FileSystem) listxattr(file *putio.File, resp *fuse.ListxattrResponse) {
+	if file.ID < 0 {
+		return
+	}
+	listPutioXattrs(file, resp)
+	if file.IsShared {
+		resp.Append(sharedWithXattr)
+	}
+
+	if f.meta == nil {
+		return
+	}
+	m, _ := f.meta.get(file.ID)
+	var names []string
+	for name := range m.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp.Append(names...)
+}
+
+// setxattr stores a user defined attribute of the given file in the metadata
+// overlay. Setting user.putio.shared_with shares the file.
+func (f *FileSystem) setxattr(ctx context.Context, id int64, req *fuse.SetxattrRequest) error {
+	if id < 0 {
+		return fuse.ENOTSUP
+	}
+	if req.Name == sharedWithXattr {
+		names, err := parseNames(req.Xattr)
+		if err != nil {
+			f.logger.Printf("could not read the friends to share %v with: %v", id, err)
+			return fuse.Errno(syscall.EINVAL)
+		}
+		err = f.shareWith(ctx, id, names)
+		if _, ok := err.(fuse.ErrorNumber); !ok && err != nil {
+			f.logger.Printf("%v", err)
+			return fuse.EIO
+		}
+		return err
+	}
+	if isPutioXattr(req.Name) {
+		return fuse.EPERM
+	}
+	if f.meta == nil || !isUserXattr(req.Name) {
+		return fuse.ENOTSUP
+	}
+
+	var errno error
+	f.meta.update(id, func(m *fileMeta) {
+		_, exists := m.Xattrs[req.Name]
+		switch {
+		case req.Flags&xattrCreate != 0 && exists:
+			errno = fuse.EEXIST
+			return
+		case req.Flags&xattrReplace != 0 && !exists:
+			errno = fuse.ErrNoXattr
+			return
+		}
+
+		// copy on write, the map may be in use by readers.
+		xattrs := make(map[string][]byte, len(m.Xattrs)+1)
+		for name, value := range m.Xattrs {
+			xattrs[name] = value
+		}
+		xattrs[req.Name] = append([]byte(nil), req.Xattr...)
+		m.Xattrs = xattrs
+	})
+	return errno
+}
+
+// removexattr removes a user defined attribute of the given file from the
+// metadata overlay. Removing user.putio.shared_with unshares the file.
+func (f *FileSystem) removexattr(ctx context.Context, id int64, req *fuse.RemovexattrRequest) error {
+	if id < 0 {
+		return fuse.ENOTSUP
+	}
+	if req.Name == sharedWithXattr {
+		if err := f.unshare(ctx, id, nil); err != nil {
+			f.logger.Printf("could not unshare %v: %v", id, err)
+			return fuse.EIO
+		}
+		return nil
+	}
+	if isPutioXattr(req.Name) {
+		return fuse.EPERM
+	}
+	if f.meta == nil {
+		return fuse.ErrNoXattr
+	}
+
+	var errno error
+	f.meta.update(id, func(m *fileMeta) {
+		if _, ok := m.Xattrs[req.Name]; !ok {
+			errno = fuse.ErrNoXattr
+			return
+		}
+
+		xattrs := make(map[string][]byte, len(m.Xattrs))
+		for name, value := range m.Xattrs {
+			if name != req.Name {
+				xattrs[name] = value
+			}
+		}
+		m.Xattrs = xattrs
+	})
+	return errno
+}
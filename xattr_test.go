@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/putdotio/go-putio/putio"
+)
+
+func TestPutioXattrs(t *testing.T) {
+	accessed := time.Date(2018, 3, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		file putio.File
+		want map[string]string
+	}{
+		{
+			putio.File{ID: 42, ParentID: 7},
+			map[string]string{
+				"user.putio.id":               "42",
+				"user.putio.parent_id":        "7",
+				"user.putio.is_mp4_available": "false",
+				"user.putio.is_shared":        "false",
+			},
+		},
+		{
+			putio.File{
+				ID:                42,
+				ContentType:       "video/mp4",
+				CRC32:             "deadbeef",
+				OpensubtitlesHash: "8e245d9679d31e12",
+				IsMP4Available:    true,
+				IsShared:          true,
+				Screenshot:        "https://put.io/screenshot/42.jpg",
+				FirstAccessedAt:   &putio.Time{Time: accessed},
+			},
+			map[string]string{
+				"user.putio.id":                 "42",
+				"user.putio.parent_id":          "0",
+				"user.putio.content_type":       "video/mp4",
+				"user.putio.crc32":              "deadbeef",
+				"user.putio.opensubtitles_hash": "8e245d9679d31e12",
+				"user.putio.is_mp4_available":   "true",
+				"user.putio.is_shared":          "true",
+				"user.putio.screenshot":         "https://put.io/screenshot/42.jpg",
+				"user.putio.first_accessed_at":  "2018-03-14T12:00:00Z",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := putioXattrs(&tt.file); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("putioXattrs(%+v) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
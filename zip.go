@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/putdotio/go-putio/putio"
+	"golang.org/x/net/context"
+)
+
+const (
+	// zipSuffix is the suffix of the virtual zip file of a directory. The
+	// zip of "dir" is "dir.zip" next to it.
+	zipSuffix = ".zip"
+
+	// zipPollInterval is how often the state of a zip is checked while
+	// Put.io builds it.
+	zipPollInterval = 2 * time.Second
+
+	// zipTimeout is how long to wait for Put.io to build a zip.
+	zipTimeout = time.Hour
+
+	// zipValidFor is how long a built zip is reused before a new one is
+	// requested.
+	zipValidFor = time.Hour
+)
+
+// zipFile is the zip of a directory. Opening it asks Put.io to build the zip
+// and waits for it, reading it streams the built zip.
+//
+// Zip files are only looked up, they are not listed: listing them would make
+// tools walking the mount zip every directory. Their size is 0 until the zip
+// is built, so they are read with direct I/O.
+type zipFile struct {
+	fs  *FileSystem
+	dir *putio.File
+	*zipBuild
+}
+
+// zipBuild is the last zip built for a directory.
+type zipBuild struct {
+	// buildMu serializes the builds, mu guards the last built zip.
+	buildMu sync.Mutex
+	mu      sync.Mutex
+	zip     *putio.Zip
+	builtAt time.Time
+}
+
+// zipBuilds keeps the zips built for directories by directory ID, so that
+// they are reused by the zip files looked up later.
+type zipBuilds struct {
+	mu     sync.Mutex
+	builds map[int64]*zipBuild
+}
+
+// get returns the zip build of the directory with the given ID. The builds
+// that are too old to be reused are dropped.
+func (b *zipBuilds) get(id int64) *zipBuild {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.builds == nil {
+		b.builds = make(map[int64]*zipBuild)
+	}
+	for dir, build := range b.builds {
+		build.mu.Lock()
+		expired := build.zip != nil && time.Since(build.builtAt) >= zipValidFor
+		build.mu.Unlock()
+		if expired && dir != id {
+			delete(b.builds, dir)
+		}
+	}
+
+	build, ok := b.builds[id]
+	if !ok {
+		build = &zipBuild{}
+		b.builds[id] = build
+	}
+	return build
+}
+
+var (
+	_ fs.Node         = (*zipFile)(nil)
+	_ fs.NodeOpener   = (*zipFile)(nil)
+	_ fs.HandleReader = (*zipHandle)(nil)
+)
+
+// Attr implements fs.Node interface. The size is unknown until the zip is
+// built.
+func (z *zipFile) Attr(ctx context.Context, attr *fuse.Attr) error {
+	z.fs.logger.Debugf("zipFile.Attr(%q)", z.dir.Name)
+
+	z.fs.perms.file(attr, true)
+	if z.dir.CreatedAt != nil {
+		attr.Ctime = z.dir.CreatedAt.Time
+		attr.Mtime = z.dir.CreatedAt.Time
+		attr.Crtime = z.dir.CreatedAt.Time
+	}
+
+	z.mu.Lock()
+	if z.zip != nil {
+		attr.Size = uint64(z.zip.Size)
+	}
+	z.mu.Unlock()
+	return nil
+}
+
+// Open implements fs.NodeOpener interface. It blocks until the zip is built.
+func (z *zipFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	z.fs.logger.Debugf("zipFile.Open(%q)", z.dir.Name)
+
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.EPERM
+	}
+
+	zip, err := z.build(ctx)
+	if err == fuse.EINTR {
+		return nil, err
+	}
+	if err != nil {
+		z.fs.logger.Printf("could not zip %q: %v", z.dir.Name, err)
+		return nil, fuse.EIO
+	}
+
+	// the size was not known when the file was looked up.
+	resp.Flags |= fuse.OpenDirectIO
+	return &zipHandle{fs: z.fs, zip: zip}, nil
+}
+
+// build returns the zip of the directory, asking Put.io to build it if there
+// is no recent one.
+func (z *zipFile) build(ctx context.Context) (putio.Zip, error) {
+	z.buildMu.Lock()
+	defer z.buildMu.Unlock()
+
+	z.mu.Lock()
+	if z.zip != nil && time.Since(z.builtAt) < zipValidFor {
+		zip := *z.zip
+		z.mu.Unlock()
+		return zip, nil
+	}
+	z.mu.Unlock()
+
+	id, err := z.fs.putio.Zips.Create(ctx, z.dir.ID)
+	if err != nil {
+		return putio.Zip{}, err
+	}
+
+	deadline := time.Now().Add(zipTimeout)
+	for {
+		zip, err := z.fs.putio.Zips.Get(ctx, id)
+		if err != nil {
+			return putio.Zip{}, err
+		}
+
+		z.fs.logger.Debugf("zip %v of %q is %v", id, z.dir.Name, zip.Status)
+		if zip.URL != "" {
+			z.mu.Lock()
+			z.zip = &zip
+			z.builtAt = time.Now()
+			z.mu.Unlock()
+			return zip, nil
+		}
+		switch strings.ToUpper(zip.Status) {
+		case "ERROR", "FAILED":
+			return putio.Zip{}, fmt.Errorf("zip %v failed", id)
+		}
+		if time.Now().After(deadline) {
+			return putio.Zip{}, fmt.Errorf("zip %v is not ready after %v", id, zipTimeout)
+		}
+
+		select {
+		case <-time.After(zipPollInterval):
+		case <-ctx.Done():
+			return putio.Zip{}, fuse.EINTR
+		}
+	}
+}
+
+// zipHandle reads a built zip.
+type zipHandle struct {
+	fs  *FileSystem
+	zip putio.Zip
+}
+
+// Read implements fs.HandleReader interface.
+func (h *zipHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if req.Offset >= h.zip.Size {
+		return nil
+	}
+	size := int(minInt64(int64(req.Size), h.zip.Size-req.Offset))
+
+	body, err := h.fs.downloadURL(ctx, h.zip.URL, req.Offset, size)
+	if err != nil {
+		h.fs.logger.Printf("could not download zip %v: %v", h.zip.ID, err)
+		return fuse.EIO
+	}
+	defer body.Close()
+
+	buf := make([]byte, size)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		h.fs.logger.Printf("could not read zip %v: %v", h.zip.ID, err)
+		return fuse.EIO
+	}
+	resp.Data = buf[:n]
+	return nil
+}